@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthAPIKey is the Method of principals authenticated by APIKeyAuth
+const AuthAPIKey = "apikey"
+
+// APIKey is a key record kept by APIKeyStore. Keys are "id.secret" strings, the store has only the id and
+// the hash of the whole key, so a leaked store doesn't leak usable keys, see GenerateAPIKey and HashAPIKey.
+type APIKey struct {
+	ID        string
+	Hash      string    // hex sha256 of the whole key, see HashAPIKey
+	Name      string    // optional owner of the key, used as the principal's username, ID if empty
+	Scopes    []string  // what the key allows, see RequireScope
+	ExpiresAt time.Time // optional, zero for keys that never expire
+}
+
+// APIKeyStore keeps API keys by id. Implementations have to be safe for concurrent use.
+type APIKeyStore interface {
+	// Get returns the key with the id, nil if there is no such key
+	Get(ctx context.Context, id string) (*APIKey, error)
+}
+
+// APIKeyConfig defines where APIKeyAuth middleware takes the key from.
+// Use APIKeyOpt functions to customize.
+type APIKeyConfig struct {
+	// Bearer takes the key from "Authorization: Bearer <key>" header. Default: true
+	Bearer bool
+	// Header is the name of a custom header with the key, like X-API-Key. Default: none
+	Header string
+	// Query is the name of a query param with the key. Keys in urls end up in access logs and browser
+	// history, so use it only for clients that can't set headers. Default: none
+	Query string
+}
+
+// APIKeyOpt is a functional option for APIKeyConfig
+type APIKeyOpt func(*APIKeyConfig)
+
+// APIKeyBearer enables or disables taking the key from "Authorization: Bearer <key>" header
+func APIKeyBearer(enabled bool) APIKeyOpt {
+	return func(c *APIKeyConfig) {
+		c.Bearer = enabled
+	}
+}
+
+// APIKeyHeader sets the name of a custom header with the key
+func APIKeyHeader(name string) APIKeyOpt {
+	return func(c *APIKeyConfig) {
+		c.Header = name
+	}
+}
+
+// APIKeyQuery sets the name of a query param with the key
+func APIKeyQuery(name string) APIKeyOpt {
+	return func(c *APIKeyConfig) {
+		c.Query = name
+	}
+}
+
+// APIKeyAuth middleware requires an API key, checked against the store. The key is taken from the first
+// enabled source having it: Authorization Bearer header, the custom header, the query param. A request without
+// a key gets StatusUnauthorized (401), so does a request with an unknown, wrong or expired key, both with
+// "WWW-Authenticate: Bearer" header. A store failure is StatusInternalServerError (500).
+// The key's principal, with AuthAPIKey method and the key's scopes, goes to the context, see GetPrincipal.
+func APIKeyAuth(store APIKeyStore, opts ...APIKeyOpt) func(http.Handler) http.Handler {
+	cfg := APIKeyConfig{Bearer: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := cfg.key(r)
+			if key == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "api key required"})
+				return
+			}
+			rec, err := checkAPIKey(r.Context(), store, key, time.Now())
+			if err != nil {
+				_ = EncodeJSON(w, http.StatusInternalServerError, JSON{"error": "can't check api key"})
+				return
+			}
+			if rec == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "invalid api key"})
+				return
+			}
+			name := rec.Name
+			if name == "" {
+				name = rec.ID
+			}
+			h.ServeHTTP(w, withPrincipal(r, Principal{Username: name, Method: AuthAPIKey, Scopes: rec.Scopes}))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScope middleware requires the authenticated principal to have all the scopes. It goes after an auth
+// middleware, like APIKeyAuth. A request without a principal gets StatusUnauthorized (401), one missing
+// any of the scopes gets StatusForbidden (403) with "WWW-Authenticate: Bearer error="insufficient_scope"".
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			p, ok := GetPrincipal(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "unauthorized"})
+				return
+			}
+			for _, s := range scopes {
+				if !p.HasScope(s) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+					_ = EncodeJSON(w, http.StatusForbidden, JSON{"error": "insufficient scope"})
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GenerateAPIKey makes a new random key with the id, to give to the client, and its hash, to keep in the store.
+// The id can't have dots.
+func GenerateAPIKey(id string) (key, hash string, err error) {
+	if id == "" || strings.Contains(id, ".") {
+		return "", "", errors.New("api key id can't be empty or have dots")
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	key = id + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns hex sha256 of the key, as kept in APIKey.Hash. Keys are random, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c APIKeyConfig) key(r *http.Request) string {
+	if c.Bearer {
+		if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+			if token = strings.TrimSpace(token); token != "" {
+				return token
+			}
+		}
+	}
+	if c.Header != "" {
+		if v := strings.TrimSpace(r.Header.Get(c.Header)); v != "" {
+			return v
+		}
+	}
+	if c.Query != "" {
+		return r.URL.Query().Get(c.Query)
+	}
+	return ""
+}
+
+// checkAPIKey returns the record of a valid key, nil for a malformed, unknown, wrong or expired one
+func checkAPIKey(ctx context.Context, store APIKeyStore, key string, now time.Time) (*APIKey, error) {
+	id, _, ok := strings.Cut(key, ".")
+	if !ok || id == "" {
+		return nil, nil
+	}
+	rec, err := store.Get(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(strings.ToLower(rec.Hash))) != 1 {
+		return nil, nil
+	}
+	if !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt) {
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+// NewMemoryAPIKeyStore makes a store with the keys
+func NewMemoryAPIKeyStore(keys ...APIKey) *MemoryAPIKeyStore {
+	res := &MemoryAPIKeyStore{keys: make(map[string]APIKey, len(keys))}
+	for _, k := range keys {
+		res.keys[k.ID] = k
+	}
+	return res
+}
+
+// Get returns the key with the id, nil if there is no such key
+func (s *MemoryAPIKeyStore) Get(_ context.Context, id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	return &k, nil
+}
+
+// Add adds the key, replacing one with the same id
+func (s *MemoryAPIKeyStore) Add(key APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+}
+
+// Remove removes the key with the id, revoking it
+func (s *MemoryAPIKeyStore) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, id)
+}
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, err := GenerateAPIKey("svc1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "svc1."))
+	assert.Len(t, key, len("svc1.")+43)
+	assert.Equal(t, HashAPIKey(key), hash)
+	assert.Len(t, hash, 64)
+
+	key2, _, err := GenerateAPIKey("svc1")
+	require.NoError(t, err)
+	assert.NotEqual(t, key, key2)
+
+	_, _, err = GenerateAPIKey("")
+	require.Error(t, err)
+	_, _, err = GenerateAPIKey("a.b")
+	require.Error(t, err)
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	key, hash, err := GenerateAPIKey("svc1")
+	require.NoError(t, err)
+	oldKey, oldHash, err := GenerateAPIKey("old")
+	require.NoError(t, err)
+	store := NewMemoryAPIKeyStore(
+		APIKey{ID: "svc1", Hash: hash, Name: "billing", Scopes: []string{"read", "write"}},
+		APIKey{ID: "old", Hash: oldHash, ExpiresAt: time.Now().Add(-time.Minute)},
+	)
+
+	h := APIKeyAuth(store, APIKeyHeader("X-API-Key"), APIKeyQuery("api_key"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := GetPrincipal(r.Context())
+			require.True(t, ok)
+			assert.Equal(t, Principal{Username: "billing", Method: AuthAPIKey, Scopes: []string{"read", "write"}}, p)
+			_, _ = w.Write([]byte("ok"))
+		}))
+	call := func(setup func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		setup(req)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	tbl := []struct {
+		name   string
+		setup  func(r *http.Request)
+		status int
+		auth   string
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) }, http.StatusOK, ""},
+		{"bearer lowercase", func(r *http.Request) { r.Header.Set("Authorization", "bearer "+key) }, http.StatusOK, ""},
+		{"header", func(r *http.Request) { r.Header.Set("X-API-Key", key) }, http.StatusOK, ""},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "api_key=" + key }, http.StatusOK, ""},
+		{"none", func(*http.Request) {}, http.StatusUnauthorized, "Bearer"},
+		{"basic auth", func(r *http.Request) { r.SetBasicAuth("svc1", key) }, http.StatusUnauthorized, "Bearer"},
+		{"wrong secret", func(r *http.Request) { r.Header.Set("X-API-Key", key+"x") }, http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"unknown id", func(r *http.Request) { r.Header.Set("X-API-Key", "svc2"+key[4:]) }, http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"no id", func(r *http.Request) { r.Header.Set("X-API-Key", "nodots") }, http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"expired", func(r *http.Request) { r.Header.Set("X-API-Key", oldKey) }, http.StatusUnauthorized, `Bearer error="invalid_token"`},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			w := call(tt.setup)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.auth, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+
+	store.Remove("svc1")
+	assert.Equal(t, http.StatusUnauthorized, call(func(r *http.Request) { r.Header.Set("X-API-Key", key) }).Code, "revoked")
+}
+
+func TestAPIKeyAuth_Sources(t *testing.T) {
+	key, hash, err := GenerateAPIKey("svc1")
+	require.NoError(t, err)
+	store := NewMemoryAPIKeyStore(APIKey{ID: "svc1", Hash: strings.ToUpper(hash)})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := GetPrincipal(r.Context())
+		_, _ = w.Write([]byte(p.Username))
+	})
+
+	h := APIKeyAuth(store, APIKeyBearer(false), APIKeyHeader("X-API-Key"))(ok)
+	req := httptest.NewRequest("GET", "/?api_key="+key, http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "bearer and query disabled")
+
+	req.Header.Set("X-API-Key", key)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "svc1", w.Body.String(), "id is the username without a name")
+}
+
+type failingAPIKeyStore struct{}
+
+func (failingAPIKeyStore) Get(context.Context, string) (*APIKey, error) {
+	return nil, errors.New("db down")
+}
+
+func TestAPIKeyAuth_StoreError(t *testing.T) {
+	h := APIKeyAuth(failingAPIKeyStore{})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Fatal("should not be called")
+	}))
+	req := httptest.NewRequest("GET", "/", http.NoBody)
+	req.Header.Set("Authorization", "Bearer svc1.secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error":"can't check api key"}`, w.Body.String())
+}
+
+func TestRequireScope(t *testing.T) {
+	key, hash, err := GenerateAPIKey("svc1")
+	require.NoError(t, err)
+	store := NewMemoryAPIKeyStore(APIKey{ID: "svc1", Hash: hash, Scopes: []string{"read", "write"}})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("ok")) })
+
+	tbl := []struct {
+		scopes []string
+		status int
+	}{
+		{nil, http.StatusOK},
+		{[]string{"read"}, http.StatusOK},
+		{[]string{"read", "write"}, http.StatusOK},
+		{[]string{"read", "admin"}, http.StatusForbidden},
+	}
+	for _, tt := range tbl {
+		h := APIKeyAuth(store)(RequireScope(tt.scopes...)(ok))
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, tt.status, w.Code, tt.scopes)
+		if tt.status == http.StatusForbidden {
+			assert.Equal(t, `Bearer error="insufficient_scope", scope="read admin"`, w.Header().Get("WWW-Authenticate"))
+			assert.JSONEq(t, `{"error":"insufficient scope"}`, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	RequireScope("read")(ok).ServeHTTP(w, httptest.NewRequest("GET", "/", http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "no auth middleware")
+}
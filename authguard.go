@@ -0,0 +1,326 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/rest/realip"
+)
+
+const authAttemptContextKey = "authAttempt"
+
+// AuthGuardConfig defines AuthGuard parameters.
+// Use AuthGuardOpt functions to customize.
+type AuthGuardConfig struct {
+	// UserThreshold is the number of failed attempts for a username which gets it locked out. Default: 5
+	UserThreshold int
+	// IPThreshold is the number of failed attempts from an ip, for any usernames, which gets it locked out. Default: 20
+	IPThreshold int
+	// BaseDelay is the first lockout, each next failure after it doubles the lockout. Default: 1s
+	BaseDelay time.Duration
+	// MaxDelay is the longest lockout. Default: 15m
+	MaxDelay time.Duration
+	// Reset is how long failures are remembered after the last one. Default: 1h
+	Reset time.Duration
+	// Extractor gets the client's ip. Default: nil, works as realip.Get
+	Extractor *realip.Extractor
+	// OnLockout is called when a username or an ip gets locked out. Default: none
+	OnLockout func(e LockoutEvent)
+}
+
+// AuthGuardOpt is a functional option for AuthGuardConfig
+type AuthGuardOpt func(*AuthGuardConfig)
+
+// AuthGuardThresholds sets the numbers of failed attempts locking out a username and an ip
+func AuthGuardThresholds(user, ip int) AuthGuardOpt {
+	return func(c *AuthGuardConfig) {
+		c.UserThreshold, c.IPThreshold = user, ip
+	}
+}
+
+// AuthGuardDelays sets the first and the longest lockouts
+func AuthGuardDelays(base, maxDelay time.Duration) AuthGuardOpt {
+	return func(c *AuthGuardConfig) {
+		c.BaseDelay, c.MaxDelay = base, maxDelay
+	}
+}
+
+// AuthGuardReset sets how long failures are remembered after the last one
+func AuthGuardReset(d time.Duration) AuthGuardOpt {
+	return func(c *AuthGuardConfig) {
+		c.Reset = d
+	}
+}
+
+// AuthGuardExtractor sets the extractor getting the client's ip, to honor forwarding headers from trusted proxies only
+func AuthGuardExtractor(extractor *realip.Extractor) AuthGuardOpt {
+	return func(c *AuthGuardConfig) {
+		c.Extractor = extractor
+	}
+}
+
+// AuthGuardOnLockout sets a function called on lockouts, like for logging or alerting
+func AuthGuardOnLockout(fn func(e LockoutEvent)) AuthGuardOpt {
+	return func(c *AuthGuardConfig) {
+		c.OnLockout = fn
+	}
+}
+
+// LockoutEvent is a username or an ip locked out by AuthGuard
+type LockoutEvent struct {
+	Username string // locked out username, empty for an ip lockout
+	IP       string // locked out ip, empty for a username lockout
+	Failures int
+	Until    time.Time
+}
+
+// AuthGuard protects the BasicAuth family from password guessing. Failed attempts are counted per username
+// and per ip, and once a threshold is reached the username or the ip is locked out, for BaseDelay doubled
+// with each next failure, up to MaxDelay. Requests of a locked out username or ip get StatusTooManyRequests (429)
+// with Retry-After, without checking the password. A successful login resets the username's failures.
+// It goes before the BasicAuth middleware, which reports the outcome of the check to it. Attempts being checked
+// count against the thresholds too, so concurrent guesses can't get past them; an attempt over the threshold
+// this way gets StatusTooManyRequests as well. It is safe for concurrent use; state is kept in memory and swept
+// of stale entries as it goes.
+type AuthGuard struct {
+	cfg AuthGuardConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*authGuardEntry // "u:" or "ip:" prefixed
+	sweptAt time.Time
+}
+
+type authGuardEntry struct {
+	failures int
+	inflight int // attempts reserved and not reported yet
+	last     time.Time
+	until    time.Time
+}
+
+// authAttempt is put to the context by AuthGuard, for the BasicAuth family to report the outcome to.
+// It holds the reservation made for the attempt until the outcome is reported or the request is done.
+type authAttempt struct {
+	guard          *AuthGuard
+	userKey, ipKey string
+	done           bool
+}
+
+// NewAuthGuard makes an AuthGuard with the given options
+func NewAuthGuard(opts ...AuthGuardOpt) *AuthGuard {
+	res := &AuthGuard{
+		cfg: AuthGuardConfig{
+			UserThreshold: 5,
+			IPThreshold:   20,
+			BaseDelay:     time.Second,
+			MaxDelay:      15 * time.Minute,
+			Reset:         time.Hour,
+		},
+		now:     time.Now,
+		entries: map[string]*authGuardEntry{},
+	}
+	for _, opt := range opts {
+		opt(&res.cfg)
+	}
+	res.cfg.UserThreshold = max(res.cfg.UserThreshold, 1)
+	res.cfg.IPThreshold = max(res.cfg.IPThreshold, 1)
+	return res
+}
+
+// Handler is the middleware rejecting locked out clients and counting failed attempts of the others
+func (g *AuthGuard) Handler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var userKey, ipKey string
+		user, _, withCredentials := r.BasicAuth()
+		if withCredentials && user != "" {
+			userKey = "u:" + user
+		}
+		if ip, err := g.cfg.Extractor.Get(r); err == nil {
+			ipKey = "ip:" + normalizeBanIP(ip)
+		}
+
+		if until, locked := g.reserve(userKey, ipKey, withCredentials); locked {
+			retry := int(until.Sub(g.now()).Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+			_ = EncodeJSON(w, http.StatusTooManyRequests, JSON{"error": "too many failed attempts"})
+			return
+		}
+		if !withCredentials {
+			h.ServeHTTP(w, r) // nothing to guess, nothing reserved
+			return
+		}
+
+		attempt := &authAttempt{guard: g, userKey: userKey, ipKey: ipKey}
+		defer attempt.finish(false, false) // no outcome reported, just release the reservation
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey(authAttemptContextKey), attempt)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Unlock lifts the lockout of the username and forgets its failures
+func (g *AuthGuard) Unlock(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, "u:"+username)
+}
+
+// UnlockIP lifts the lockout of the ip and forgets its failures
+func (g *AuthGuard) UnlockIP(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, "ip:"+normalizeBanIP(ip))
+}
+
+// reserve returns the latest end of lockouts of the username and the ip keys, empty keys skipped. Otherwise,
+// with reserveAttempt, it reserves an attempt for the keys, refused as locked with until of now if the failures
+// and the attempts already reserved reach the threshold of any key. The reservation is released by finish.
+func (g *AuthGuard) reserve(userKey, ipKey string, reserveAttempt bool) (until time.Time, ok bool) {
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sweepLocked(now)
+	keys := []string{userKey, ipKey}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if e, found := g.entries[key]; found && e.until.After(now) && e.until.After(until) {
+			until, ok = e.until, true
+		}
+	}
+	if ok || !reserveAttempt {
+		return until, ok
+	}
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		// past the threshold, once the lockout is over, one attempt at a time is let through
+		if e := g.entryLocked(key, now); e.inflight > 0 && e.failures+e.inflight >= g.threshold(key, ipKey) {
+			return now, true
+		}
+	}
+	for _, key := range keys {
+		if key != "" {
+			g.entries[key].inflight++
+		}
+	}
+	return time.Time{}, false
+}
+
+// finish releases the attempt reserved for the username and the ip keys, recording a failure for both
+// and locking out those reaching the threshold, or resetting the username's failures on success
+func (g *AuthGuard) finish(userKey, ipKey string, failed, succeeded bool) {
+	now := g.now()
+	var events []LockoutEvent
+	g.mu.Lock()
+	for _, key := range []string{userKey, ipKey} {
+		if key == "" {
+			continue
+		}
+		e := g.entryLocked(key, now)
+		e.inflight = max(e.inflight-1, 0)
+		if succeeded && key == userKey {
+			e.failures, e.until = 0, time.Time{}
+		}
+		if !failed {
+			if e.failures == 0 && e.inflight == 0 {
+				delete(g.entries, key)
+			}
+			continue
+		}
+		e.failures++
+		e.last = now
+		threshold := g.threshold(key, ipKey)
+		if e.failures < threshold {
+			continue
+		}
+		e.until = now.Add(g.backoff(e.failures - threshold))
+		event := LockoutEvent{Failures: e.failures, Until: e.until}
+		if key == userKey {
+			event.Username = key[len("u:"):]
+		} else {
+			event.IP = key[len("ip:"):]
+		}
+		events = append(events, event)
+	}
+	g.mu.Unlock()
+	if g.cfg.OnLockout != nil {
+		for _, e := range events {
+			g.cfg.OnLockout(e)
+		}
+	}
+}
+
+// entryLocked returns the entry of the key, made if missing, with failures older than Reset forgotten
+func (g *AuthGuard) entryLocked(key string, now time.Time) *authGuardEntry {
+	e, found := g.entries[key]
+	if !found {
+		e = &authGuardEntry{}
+		g.entries[key] = e
+	}
+	if now.Sub(e.last) >= g.cfg.Reset && !e.until.After(now) {
+		e.failures, e.until = 0, time.Time{}
+	}
+	return e
+}
+
+// threshold returns the threshold of the username or the ip key
+func (g *AuthGuard) threshold(key, ipKey string) int {
+	if key == ipKey {
+		return g.cfg.IPThreshold
+	}
+	return g.cfg.UserThreshold
+}
+
+// backoff returns BaseDelay doubled n times, capped by MaxDelay
+func (g *AuthGuard) backoff(n int) time.Duration {
+	d := g.cfg.BaseDelay
+	for range n {
+		if d >= g.cfg.MaxDelay {
+			break
+		}
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDelay)
+}
+
+// sweepLocked drops entries with expired lockouts and failures older than Reset, at most once per minute
+func (g *AuthGuard) sweepLocked(now time.Time) {
+	if now.Sub(g.sweptAt) < time.Minute {
+		return
+	}
+	g.sweptAt = now
+	for key, e := range g.entries {
+		if e.inflight == 0 && !e.until.After(now) && now.Sub(e.last) >= g.cfg.Reset {
+			delete(g.entries, key)
+		}
+	}
+}
+
+// finish reports the outcome of the attempt to AuthGuard once, releasing its reservation
+func (a *authAttempt) finish(failed, succeeded bool) {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.guard.finish(a.userKey, a.ipKey, failed, succeeded)
+}
+
+// reportAuthFailure tells AuthGuard, if any, the credentials of the request failed the check
+func reportAuthFailure(r *http.Request) {
+	if a, ok := r.Context().Value(contextKey(authAttemptContextKey)).(*authAttempt); ok {
+		a.finish(true, false)
+	}
+}
+
+// reportAuthSuccess tells AuthGuard, if any, the credentials of the request passed the check
+func reportAuthSuccess(r *http.Request) {
+	if a, ok := r.Context().Value(contextKey(authAttemptContextKey)).(*authAttempt); ok {
+		a.finish(false, true)
+	}
+}
@@ -0,0 +1,205 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthGuard(t *testing.T) {
+	var events []LockoutEvent
+	g := NewAuthGuard(AuthGuardThresholds(3, 100), AuthGuardDelays(time.Second, 5*time.Second),
+		AuthGuardOnLockout(func(e LockoutEvent) { events = append(events, e) }))
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	h := g.Handler(BasicAuthWithUserPasswd("bob", "passwd")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})))
+	call := func(user, passwd string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.RemoteAddr = "1.1.1.1:1234"
+		if user != "" {
+			req.SetBasicAuth(user, passwd)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call("", "").Code, "no credentials, not counted")
+	assert.Equal(t, http.StatusForbidden, call("bob", "bad1").Code)
+	assert.Equal(t, http.StatusForbidden, call("bob", "bad2").Code)
+	assert.Empty(t, events)
+	assert.Equal(t, http.StatusForbidden, call("bob", "bad3").Code)
+	assert.Equal(t, []LockoutEvent{{Username: "bob", Failures: 3, Until: now.Add(time.Second)}}, events)
+
+	w := call("bob", "passwd")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "locked out even with the right password")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"too many failed attempts"}`, w.Body.String())
+	assert.Equal(t, http.StatusForbidden, call("amy", "bad").Code, "other users not affected")
+
+	// each next failure doubles the lockout, up to the max
+	for i, d := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		now = now.Add(events[len(events)-1].Until.Sub(now))
+		assert.Equal(t, http.StatusForbidden, call("bob", "bad").Code)
+		require.Len(t, events, i+2)
+		assert.Equal(t, now.Add(d), events[i+1].Until)
+		assert.Equal(t, strconv.Itoa(int(d/time.Second)), call("bob", "passwd").Header().Get("Retry-After"))
+	}
+
+	// success resets the username's failures
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, http.StatusOK, call("bob", "passwd").Code)
+	assert.Equal(t, http.StatusForbidden, call("bob", "bad").Code)
+	assert.Equal(t, http.StatusOK, call("bob", "passwd").Code, "one failure after reset doesn't lock")
+}
+
+func TestAuthGuard_IP(t *testing.T) {
+	var events []LockoutEvent
+	g := NewAuthGuard(AuthGuardThresholds(100, 3), AuthGuardOnLockout(func(e LockoutEvent) { events = append(events, e) }))
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+	h := g.Handler(BasicAuthWithUserPasswd("bob", "passwd")(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	call := func(ip, user string) int {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.RemoteAddr = net.JoinHostPort(ip, "1234")
+		req.SetBasicAuth(user, "bad")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// spraying different usernames from one ip
+	assert.Equal(t, http.StatusForbidden, call("1.1.1.1", "u1"))
+	assert.Equal(t, http.StatusForbidden, call("1.1.1.1", "u2"))
+	assert.Equal(t, http.StatusForbidden, call("::ffff:1.1.1.1", "u3"))
+	assert.Equal(t, []LockoutEvent{{IP: "1.1.1.1", Failures: 3, Until: now.Add(time.Second)}}, events)
+	assert.Equal(t, http.StatusTooManyRequests, call("1.1.1.1", "u4"))
+	assert.Equal(t, http.StatusForbidden, call("2.2.2.2", "u4"), "other ips not affected")
+
+	g.UnlockIP("1.1.1.1")
+	assert.Equal(t, http.StatusForbidden, call("1.1.1.1", "u4"))
+
+	// failures are forgotten after reset time
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusForbidden, call("1.1.1.1", "u5"))
+	assert.Equal(t, http.StatusForbidden, call("1.1.1.1", "u6"))
+	assert.Len(t, events, 1)
+}
+
+func TestAuthGuard_BasicAuthFamily(t *testing.T) {
+	hash, err := GenerateBcryptHash("passwd")
+	require.NoError(t, err)
+	argonHash, argonSalt, err := GenerateArgon2Hash("passwd")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("bob:"+hash+"\n"), 0o600))
+	users, err := NewHtpasswd(path)
+	require.NoError(t, err)
+
+	tbl := []struct {
+		name       string
+		mw         func(http.Handler) http.Handler
+		failStatus int
+	}{
+		{"BasicAuth", BasicAuth(func(user, passwd string) bool { return user == "bob" && passwd == "passwd" }), http.StatusForbidden},
+		{"BasicAuthWithUserPasswd", BasicAuthWithUserPasswd("bob", "passwd"), http.StatusForbidden},
+		{"BasicAuthWithBcryptHash", BasicAuthWithBcryptHash("bob", hash), http.StatusForbidden},
+		{"BasicAuthWithArgon2Hash", BasicAuthWithArgon2Hash("bob", argonHash, argonSalt), http.StatusForbidden},
+		{"BasicAuthWithPrompt", BasicAuthWithPrompt("bob", "passwd"), http.StatusUnauthorized},
+		{"BasicAuthWithBcryptHashAndPrompt", BasicAuthWithBcryptHashAndPrompt("bob", hash), http.StatusUnauthorized},
+		{"BasicAuthWithHtpasswd", BasicAuthWithHtpasswd(users), http.StatusForbidden},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewAuthGuard(AuthGuardThresholds(2, 100))
+			h := g.Handler(tt.mw(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+			call := func(passwd string) int {
+				req := httptest.NewRequest("GET", "/", http.NoBody)
+				req.SetBasicAuth("bob", passwd)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				return w.Code
+			}
+			assert.Equal(t, http.StatusOK, call("passwd"))
+			assert.Equal(t, tt.failStatus, call("bad"))
+			assert.Equal(t, tt.failStatus, call("bad"))
+			assert.Equal(t, http.StatusTooManyRequests, call("passwd"))
+			g.Unlock("bob")
+			assert.Equal(t, http.StatusOK, call("passwd"))
+		})
+	}
+}
+
+func TestAuthGuard_Concurrent(t *testing.T) {
+	g := NewAuthGuard(AuthGuardThresholds(3, 100))
+	release := make(chan struct{})
+	var checked atomic.Int32
+	checker := func(user, passwd string) bool {
+		checked.Add(1)
+		<-release // all the guesses are in the check at once
+		return false
+	}
+	h := g.Handler(BasicAuth(checker)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	const guesses = 20
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", http.NoBody)
+			req.SetBasicAuth("bob", "guess"+strconv.Itoa(i))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	require.Eventually(t, func() bool { return len(codes) == guesses-3 }, time.Second, time.Millisecond,
+		"all but the threshold refused without waiting for the check")
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusForbidden: 3, http.StatusTooManyRequests: guesses - 3}, counts)
+	assert.Equal(t, int32(3), checked.Load(), "passwords checked up to the threshold only")
+
+	req := httptest.NewRequest("GET", "/", http.NoBody)
+	req.SetBasicAuth("bob", "next")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "locked out after the reserved attempts failed")
+}
+
+func TestAuthGuard_Sweep(t *testing.T) {
+	g := NewAuthGuard(AuthGuardThresholds(1, 1))
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+	_, locked := g.reserve("u:bob", "ip:1.1.1.1", true)
+	require.False(t, locked)
+	g.finish("u:bob", "ip:1.1.1.1", true, false)
+
+	now = now.Add(time.Hour)
+	_, locked = g.reserve("u:amy", "", false)
+	assert.False(t, locked)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	assert.Empty(t, g.entries)
+}
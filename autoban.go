@@ -0,0 +1,307 @@
+package rest
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/rest/realip"
+)
+
+// AutoBanConfig defines AutoBan parameters.
+// Use AutoBanOpt functions to customize.
+type AutoBanConfig struct {
+	// Statuses are the response codes counted as bad outcomes. Default: 401, 403, 404, 413
+	Statuses []int
+	// Threshold is the number of bad outcomes within Window which gets the client banned. Default: 10
+	Threshold int
+	// Window is the sliding window bad outcomes are counted over. Default: 1m
+	Window time.Duration
+	// BanFor is how long a ban lasts. Default: 15m
+	BanFor time.Duration
+	// Extractor gets the client's ip. Default: nil, works as realip.Get
+	Extractor *realip.Extractor
+	// OnBan is called when a client gets banned, automatically or with Ban. Default: none
+	OnBan func(ip string, until time.Time)
+}
+
+// AutoBanOpt is a functional option for AutoBanConfig
+type AutoBanOpt func(*AutoBanConfig)
+
+// AutoBanStatuses sets the response codes counted as bad outcomes
+func AutoBanStatuses(codes ...int) AutoBanOpt {
+	return func(c *AutoBanConfig) {
+		c.Statuses = codes
+	}
+}
+
+// AutoBanThreshold sets the number of bad outcomes within the window which gets the client banned
+func AutoBanThreshold(n int) AutoBanOpt {
+	return func(c *AutoBanConfig) {
+		c.Threshold = n
+	}
+}
+
+// AutoBanWindow sets the sliding window bad outcomes are counted over
+func AutoBanWindow(d time.Duration) AutoBanOpt {
+	return func(c *AutoBanConfig) {
+		c.Window = d
+	}
+}
+
+// AutoBanDuration sets how long a ban lasts
+func AutoBanDuration(d time.Duration) AutoBanOpt {
+	return func(c *AutoBanConfig) {
+		c.BanFor = d
+	}
+}
+
+// AutoBanExtractor sets the extractor getting the client's ip, to honor forwarding headers from trusted proxies only
+func AutoBanExtractor(extractor *realip.Extractor) AutoBanOpt {
+	return func(c *AutoBanConfig) {
+		c.Extractor = extractor
+	}
+}
+
+// AutoBanOnBan sets a function called when a client gets banned, like for logging or alerting
+func AutoBanOnBan(fn func(ip string, until time.Time)) AutoBanOpt {
+	return func(c *AutoBanConfig) {
+		c.OnBan = fn
+	}
+}
+
+// BanEntry is a banned client
+type BanEntry struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// AutoBan bans clients, by ip, getting too many bad responses, like failed BasicAuth (401), rejections of
+// SizeLimit (413) or BlackWords (403), or 404 from scanning for paths. Requests of a banned client are
+// rejected with StatusForbidden (403) and Retry-After until the ban expires or is lifted. It is safe for
+// concurrent use; state is kept in memory and swept of expired entries as it goes.
+type AutoBan struct {
+	cfg AutoBanConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	hits    map[string][]time.Time // bad outcomes within the window, oldest first
+	bans    map[string]time.Time
+	sweptAt time.Time
+}
+
+// NewAutoBan makes an AutoBan with the given options
+func NewAutoBan(opts ...AutoBanOpt) *AutoBan {
+	res := &AutoBan{
+		cfg: AutoBanConfig{
+			Statuses:  []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge},
+			Threshold: 10,
+			Window:    time.Minute,
+			BanFor:    15 * time.Minute,
+		},
+		now:  time.Now,
+		hits: map[string][]time.Time{},
+		bans: map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(&res.cfg)
+	}
+	res.cfg.Threshold = max(res.cfg.Threshold, 1)
+	return res
+}
+
+// Handler is the middleware rejecting banned clients and counting bad outcomes of the others
+func (b *AutoBan) Handler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ip, err := b.cfg.Extractor.Get(r)
+		if err != nil {
+			h.ServeHTTP(w, r) // nothing to count bad outcomes against
+			return
+		}
+		ip = normalizeBanIP(ip)
+
+		if until, banned := b.banned(ip); banned {
+			retry := int(until.Sub(b.now()).Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+			_ = EncodeJSON(w, http.StatusForbidden, JSON{"error": "ip banned"})
+			return
+		}
+
+		sw := &autoBanWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if slices.Contains(b.cfg.Statuses, sw.status) {
+			b.hit(ip)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Ban bans the ip for the given duration, BanFor if zero or negative
+func (b *AutoBan) Ban(ip string, d time.Duration) {
+	if d <= 0 {
+		d = b.cfg.BanFor
+	}
+	ip = normalizeBanIP(ip)
+	until := b.now().Add(d)
+	b.mu.Lock()
+	b.bans[ip] = until
+	delete(b.hits, ip)
+	b.mu.Unlock()
+	if b.cfg.OnBan != nil {
+		b.cfg.OnBan(ip, until)
+	}
+}
+
+// Unban lifts the ban of the ip and forgets its bad outcomes, reporting whether it was banned
+func (b *AutoBan) Unban(ip string) bool {
+	ip = normalizeBanIP(ip)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.bans[ip]
+	delete(b.bans, ip)
+	delete(b.hits, ip)
+	return ok && until.After(b.now())
+}
+
+// List returns the clients banned now, sorted by ip
+func (b *AutoBan) List() []BanEntry {
+	now := b.now()
+	b.mu.Lock()
+	res := make([]BanEntry, 0, len(b.bans))
+	for ip, until := range b.bans {
+		if until.After(now) {
+			res = append(res, BanEntry{IP: ip, Until: until})
+		}
+	}
+	b.mu.Unlock()
+	slices.SortFunc(res, func(a, b BanEntry) int { return strings.Compare(a.IP, b.IP) })
+	return res
+}
+
+// AdminHandler serves the bans: GET lists them, DELETE with "ip" query parameter lifts a ban, and POST with
+// "ip" and optional "duration", like "1h", bans the ip. It has no protection of its own, mount it behind auth.
+func (b *AutoBan) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.URL.Query().Get("ip")
+		switch r.Method {
+		case http.MethodGet:
+			_ = EncodeJSON(w, http.StatusOK, b.List())
+		case http.MethodDelete:
+			if ip == "" {
+				_ = EncodeJSON(w, http.StatusBadRequest, JSON{"error": "missing ip"})
+				return
+			}
+			if !b.Unban(ip) {
+				_ = EncodeJSON(w, http.StatusNotFound, JSON{"error": "ip not banned"})
+				return
+			}
+			_ = EncodeJSON(w, http.StatusOK, JSON{"unbanned": normalizeBanIP(ip)})
+		case http.MethodPost:
+			if _, err := netip.ParseAddr(ip); err != nil {
+				_ = EncodeJSON(w, http.StatusBadRequest, JSON{"error": "missing or invalid ip"})
+				return
+			}
+			var d time.Duration
+			if v := r.URL.Query().Get("duration"); v != "" {
+				var err error
+				if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+					_ = EncodeJSON(w, http.StatusBadRequest, JSON{"error": "invalid duration"})
+					return
+				}
+			}
+			b.Ban(ip, d)
+			_ = EncodeJSON(w, http.StatusOK, JSON{"banned": normalizeBanIP(ip)})
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			_ = EncodeJSON(w, http.StatusMethodNotAllowed, JSON{"error": "method not allowed"})
+		}
+	})
+}
+
+func (b *AutoBan) banned(ip string) (until time.Time, ok bool) {
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweepLocked(now)
+	until, ok = b.bans[ip]
+	return until, ok && until.After(now)
+}
+
+// hit records a bad outcome of the ip, banning it once the threshold is reached within the window
+func (b *AutoBan) hit(ip string) {
+	now := b.now()
+	b.mu.Lock()
+	hits := b.hits[ip]
+	start := 0
+	for start < len(hits) && now.Sub(hits[start]) >= b.cfg.Window {
+		start++
+	}
+	hits = append(hits[start:], now)
+	if len(hits) < b.cfg.Threshold {
+		b.hits[ip] = hits
+		b.mu.Unlock()
+		return
+	}
+	until := now.Add(b.cfg.BanFor)
+	b.bans[ip] = until
+	delete(b.hits, ip)
+	b.mu.Unlock()
+	if b.cfg.OnBan != nil {
+		b.cfg.OnBan(ip, until)
+	}
+}
+
+// sweepLocked drops expired bans and clients without bad outcomes within the window, at most once per window
+func (b *AutoBan) sweepLocked(now time.Time) {
+	if now.Sub(b.sweptAt) < b.cfg.Window {
+		return
+	}
+	b.sweptAt = now
+	for ip, until := range b.bans {
+		if !until.After(now) {
+			delete(b.bans, ip)
+		}
+	}
+	for ip, hits := range b.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= b.cfg.Window {
+			delete(b.hits, ip)
+		}
+	}
+}
+
+// normalizeBanIP makes different spellings of the same address one key
+func normalizeBanIP(ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap().String()
+	}
+	return ip
+}
+
+// autoBanWriter records the status of the response
+type autoBanWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *autoBanWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *autoBanWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the original writer, for http.ResponseController
+func (w *autoBanWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
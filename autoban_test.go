@@ -0,0 +1,123 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoBan(t *testing.T) {
+	var bans []string
+	ab := NewAutoBan(AutoBanThreshold(3), AutoBanWindow(time.Minute), AutoBanDuration(10*time.Minute),
+		AutoBanOnBan(func(ip string, _ time.Time) { bans = append(bans, ip) }))
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	ab.now = func() time.Time { return now }
+
+	h := ab.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	get := func(ip, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, http.NoBody)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, get("1.1.1.1", "/missing").Code)
+	assert.Equal(t, http.StatusNotFound, get("1.1.1.1", "/missing").Code)
+	now = now.Add(time.Minute) // the first two slide out of the window
+	assert.Equal(t, http.StatusNotFound, get("1.1.1.1", "/missing").Code)
+	assert.Equal(t, http.StatusOK, get("1.1.1.1", "/").Code, "good responses are not counted")
+	assert.Equal(t, http.StatusNotFound, get("1.1.1.1", "/missing").Code)
+	assert.Empty(t, bans)
+	assert.Equal(t, http.StatusNotFound, get("1.1.1.1", "/missing").Code)
+	assert.Equal(t, []string{"1.1.1.1"}, bans)
+
+	w := get("1.1.1.1", "/")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "600", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"ip banned"}`, w.Body.String())
+	assert.Equal(t, http.StatusOK, get("2.2.2.2", "/").Code, "other clients not affected")
+	assert.Equal(t, []BanEntry{{IP: "1.1.1.1", Until: now.Add(10 * time.Minute)}}, ab.List())
+
+	now = now.Add(10 * time.Minute)
+	assert.Equal(t, http.StatusOK, get("1.1.1.1", "/").Code, "ban expired")
+	assert.Empty(t, ab.List())
+}
+
+func TestAutoBan_Statuses(t *testing.T) {
+	ab := NewAutoBan(AutoBanThreshold(2), AutoBanStatuses(http.StatusUnauthorized, http.StatusForbidden))
+	h := ab.Handler(BasicAuthWithUserPasswd("user", "passwd")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})))
+	call := func(passwd string) int {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.RemoteAddr = "[::ffff:1.1.1.1]:1234"
+		req.SetBasicAuth("user", passwd)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNotFound, call("passwd"))
+	assert.Equal(t, http.StatusNotFound, call("passwd"), "404 not counted")
+	assert.Equal(t, http.StatusForbidden, call("bad"))
+	assert.Equal(t, http.StatusForbidden, call("bad"))
+	assert.Equal(t, http.StatusForbidden, call("passwd"))
+	require.Len(t, ab.List(), 1)
+	assert.Equal(t, "1.1.1.1", ab.List()[0].IP, "ipv4-mapped address normalized")
+}
+
+func TestAutoBan_AdminHandler(t *testing.T) {
+	ab := NewAutoBan()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	ab.now = func() time.Time { return now }
+	admin := ab.AdminHandler()
+	do := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, url, http.NoBody))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("POST", "/?ip=2.2.2.2&duration=1h").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/?ip=1.1.1.1").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/?ip=bad").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/?ip=3.3.3.3&duration=-1h").Code)
+
+	w := do("GET", "/")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []BanEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, []BanEntry{{IP: "1.1.1.1", Until: now.Add(15 * time.Minute)}, {IP: "2.2.2.2", Until: now.Add(time.Hour)}}, list)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/?ip=1.1.1.1").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/?ip=1.1.1.1").Code)
+	assert.Equal(t, http.StatusBadRequest, do("DELETE", "/").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do("PUT", "/").Code)
+	assert.Len(t, ab.List(), 1)
+}
+
+func TestAutoBan_Sweep(t *testing.T) {
+	ab := NewAutoBan(AutoBanThreshold(5))
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	ab.now = func() time.Time { return now }
+	ab.hit("1.1.1.1")
+	ab.Ban("2.2.2.2", time.Minute)
+
+	now = now.Add(2 * time.Minute)
+	_, banned := ab.banned("3.3.3.3")
+	assert.False(t, banned)
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	assert.Empty(t, ab.hits)
+	assert.Empty(t, ab.bans)
+}
@@ -25,10 +25,11 @@ func BasicAuth(checker func(user, passwd string) bool) func(http.Handler) http.H
 				return
 			}
 			if !checker(u, p) {
+				reportAuthFailure(r)
 				w.WriteHeader(http.StatusForbidden)
 				return
 			}
-			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey(baContextKey), true)))
+			h.ServeHTTP(w, basicAuthorized(r, u))
 		}
 		return http.HandlerFunc(fn)
 	}
@@ -81,12 +82,20 @@ func BasicAuthWithArgon2Hash(user, hashedPassword, salt string) func(http.Handle
 }
 
 // IsAuthorized returns true is user authorized.
-// it can be used in handlers to check if BasicAuth middleware was applied
+// it can be used in handlers to check if BasicAuth or ClientCert middleware was applied, GetPrincipal tells who the user is
 func IsAuthorized(ctx context.Context) bool {
 	v := ctx.Value(contextKey(baContextKey))
 	return v != nil && v.(bool)
 }
 
+// BasicAuthUser returns the user authenticated by any of the BasicAuth family, empty if none
+func BasicAuthUser(ctx context.Context) string {
+	if p, ok := GetPrincipal(ctx); ok && p.Method == AuthBasic {
+		return p.Username
+	}
+	return ""
+}
+
 // BasicAuthWithPrompt middleware requires basic auth and matches user & passwd with client-provided values
 // If the user is not authorized, it will prompt for basic auth
 func BasicAuthWithPrompt(user, passwd string) func(http.Handler) http.Handler {
@@ -102,9 +111,12 @@ func BasicAuthWithPrompt(user, passwd string) func(http.Handler) http.Handler {
 			// extract basic auth from request
 			u, p, ok := r.BasicAuth()
 			if ok && checkFn(u, p) {
-				h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey(baContextKey), true)))
+				h.ServeHTTP(w, basicAuthorized(r, u))
 				return
 			}
+			if ok {
+				reportAuthFailure(r)
+			}
 			// not authorized, prompt for basic auth
 			w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -129,9 +141,12 @@ func BasicAuthWithBcryptHashAndPrompt(user, hashedPassword string) func(http.Han
 			// extract basic auth from request
 			u, p, ok := r.BasicAuth()
 			if ok && checkFn(u, p) {
-				h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey(baContextKey), true)))
+				h.ServeHTTP(w, basicAuthorized(r, u))
 				return
 			}
+			if ok {
+				reportAuthFailure(r)
+			}
 			// not authorized, prompt for basic auth
 			w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -0,0 +1,189 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheDirectives is a set of Cache-Control directives. Durations are sent in whole seconds, zero ones are
+// left out. SurrogateMaxAge goes to Surrogate-Control, for CDNs, rather than to Cache-Control.
+type CacheDirectives struct {
+	Public               bool
+	Private              bool
+	NoCache              bool
+	NoStore              bool
+	MustRevalidate       bool
+	Immutable            bool
+	MaxAge               time.Duration
+	SMaxAge              time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	SurrogateMaxAge      time.Duration
+}
+
+// String returns the Cache-Control value of directives
+func (d CacheDirectives) String() string {
+	var res []string
+	for _, flag := range []struct {
+		on   bool
+		name string
+	}{{d.Public, "public"}, {d.Private, "private"}, {d.NoCache, "no-cache"}, {d.NoStore, "no-store"},
+		{d.MustRevalidate, "must-revalidate"}, {d.Immutable, "immutable"}} {
+		if flag.on {
+			res = append(res, flag.name)
+		}
+	}
+	for _, dur := range []struct {
+		d    time.Duration
+		name string
+	}{{d.MaxAge, "max-age"}, {d.SMaxAge, "s-maxage"}, {d.StaleWhileRevalidate, "stale-while-revalidate"},
+		{d.StaleIfError, "stale-if-error"}} {
+		if dur.d > 0 {
+			res = append(res, dur.name+"="+strconv.Itoa(int(dur.d.Seconds())))
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+// validate rejects directives contradicting each other
+func (d CacheDirectives) validate() error {
+	switch {
+	case d.MaxAge < 0 || d.SMaxAge < 0 || d.StaleWhileRevalidate < 0 || d.StaleIfError < 0 || d.SurrogateMaxAge < 0:
+		return errors.New("negative duration")
+	case d.String() == "" && d.SurrogateMaxAge == 0:
+		return errors.New("no directives")
+	case d.Public && d.Private:
+		return errors.New("public conflicts with private")
+	case d.NoStore && (d.Public || d.Private || d.NoCache || d.MustRevalidate || d.Immutable || d.MaxAge > 0 ||
+		d.SMaxAge > 0 || d.StaleWhileRevalidate > 0 || d.StaleIfError > 0 || d.SurrogateMaxAge > 0):
+		return errors.New("no-store conflicts with any other directive")
+	case d.Private && (d.SMaxAge > 0 || d.SurrogateMaxAge > 0):
+		return errors.New("private conflicts with shared cache lifetimes, s-maxage and surrogate max-age")
+	case d.Immutable && d.MaxAge == 0:
+		return errors.New("immutable requires max-age")
+	case d.Immutable && (d.NoCache || d.MustRevalidate):
+		return errors.New("immutable conflicts with revalidation, no-cache and must-revalidate")
+	case d.StaleWhileRevalidate > 0 && (d.NoCache || d.MustRevalidate):
+		return errors.New("stale-while-revalidate conflicts with no-cache and must-revalidate")
+	}
+	return nil
+}
+
+// CacheRule applies directives to responses matching it. Path is a path.Match pattern of the url path,
+// ContentType a media type like "text/html" or a prefix ending with "/" like "image/". A rule with both has to
+// match both, empty ones match anything.
+type CacheRule struct {
+	Path        string
+	ContentType string
+	Directives  CacheDirectives
+}
+
+// CachePolicy sets caching headers by declarative rules. The first rule matching the response applies.
+type CachePolicy struct {
+	rules []CacheRule
+	now   func() time.Time
+}
+
+// NewCachePolicy makes a policy of rules, checked in order. Rules with bad path patterns or conflicting
+// directives, like public with private or no-store with max-age, are rejected.
+func NewCachePolicy(rules ...CacheRule) (*CachePolicy, error) {
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Path, ""); err != nil {
+			return nil, fmt.Errorf("cache rule %d: bad path pattern %q: %w", i, rule.Path, err)
+		}
+		if err := rule.Directives.validate(); err != nil {
+			return nil, fmt.Errorf("cache rule %d: %w", i, err)
+		}
+	}
+	return &CachePolicy{rules: rules, now: time.Now}, nil
+}
+
+// Handler is the policy middleware. It sets Cache-Control, along with Expires for max-age and Surrogate-Control
+// for surrogate max-age, once the handler starts the response, so rules can match its Content-Type. Headers set
+// by the handler itself win. Error responses other than 404 and 410 are left alone, so a policy meant for
+// content doesn't make an outage cached.
+func (p *CachePolicy) Handler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&cachePolicyWriter{ResponseWriter: w, policy: p, path: r.URL.Path}, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (p *CachePolicy) apply(hdr http.Header, urlPath string, status int) {
+	if status >= 400 && status != http.StatusNotFound && status != http.StatusGone {
+		return
+	}
+	contentType := strings.ToLower(hdr.Get("Content-Type"))
+	if mt, _, found := strings.Cut(contentType, ";"); found {
+		contentType = strings.TrimSpace(mt)
+	}
+
+	for _, rule := range p.rules {
+		if rule.Path != "" {
+			if ok, _ := path.Match(rule.Path, urlPath); !ok {
+				continue
+			}
+		}
+		if ct := strings.ToLower(rule.ContentType); ct != "" {
+			if !(ct == contentType || (strings.HasSuffix(ct, "/") && strings.HasPrefix(contentType, ct))) {
+				continue
+			}
+		}
+
+		d := rule.Directives
+		if hdr.Get("Cache-Control") == "" {
+			if cc := d.String(); cc != "" {
+				hdr.Set("Cache-Control", cc)
+			}
+			if d.MaxAge > 0 && hdr.Get("Expires") == "" {
+				hdr.Set("Expires", p.now().Add(d.MaxAge).UTC().Format(http.TimeFormat))
+			}
+		}
+		if d.SurrogateMaxAge > 0 && hdr.Get("Surrogate-Control") == "" {
+			hdr.Set("Surrogate-Control", "max-age="+strconv.Itoa(int(d.SurrogateMaxAge.Seconds())))
+		}
+		return
+	}
+}
+
+// cachePolicyWriter applies the policy right before the headers go out
+type cachePolicyWriter struct {
+	http.ResponseWriter
+	policy      *CachePolicy
+	path        string
+	wroteHeader bool
+}
+
+func (w *cachePolicyWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+		w.policy.apply(w.ResponseWriter.Header(), w.path, code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cachePolicyWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the original writer, for http.ResponseController
+func (w *cachePolicyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// FlushError flushes the original writer through http.ResponseController, applying the policy first
+// if the headers haven't gone out yet
+func (w *cachePolicyWriter) FlushError() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
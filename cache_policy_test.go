@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheDirectives_String(t *testing.T) {
+	d := CacheDirectives{Public: true, Immutable: true, MaxAge: 365 * 24 * time.Hour, StaleIfError: time.Hour}
+	assert.Equal(t, "public, immutable, max-age=31536000, stale-if-error=3600", d.String())
+	assert.Equal(t, "private, no-cache", CacheDirectives{Private: true, NoCache: true}.String())
+}
+
+func TestNewCachePolicy_Validation(t *testing.T) {
+	tbl := []struct {
+		rule CacheRule
+		err  string
+	}{
+		{CacheRule{Path: "/static/*", Directives: CacheDirectives{Public: true, MaxAge: time.Hour}}, ""},
+		{CacheRule{Directives: CacheDirectives{NoStore: true}}, ""},
+		{CacheRule{Directives: CacheDirectives{SurrogateMaxAge: time.Hour}}, ""},
+		{CacheRule{Path: "/[", Directives: CacheDirectives{NoStore: true}}, "cache rule 0: bad path pattern"},
+		{CacheRule{}, "cache rule 0: no directives"},
+		{CacheRule{Directives: CacheDirectives{Public: true, Private: true}}, "public conflicts with private"},
+		{CacheRule{Directives: CacheDirectives{NoStore: true, MaxAge: time.Hour}}, "no-store conflicts"},
+		{CacheRule{Directives: CacheDirectives{Private: true, SMaxAge: time.Hour}}, "private conflicts"},
+		{CacheRule{Directives: CacheDirectives{Immutable: true}}, "immutable requires max-age"},
+		{CacheRule{Directives: CacheDirectives{Immutable: true, MaxAge: time.Hour, NoCache: true}}, "immutable conflicts"},
+		{CacheRule{Directives: CacheDirectives{MaxAge: time.Hour, MustRevalidate: true, StaleWhileRevalidate: time.Minute}},
+			"stale-while-revalidate conflicts"},
+		{CacheRule{Directives: CacheDirectives{MaxAge: -time.Hour}}, "negative duration"},
+	}
+
+	for _, tt := range tbl {
+		_, err := NewCachePolicy(tt.rule)
+		if tt.err == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tt.err)
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	policy, err := NewCachePolicy(
+		CacheRule{Path: "/static/*", Directives: CacheDirectives{Public: true, Immutable: true, MaxAge: 24 * time.Hour}},
+		CacheRule{Path: "/api/*", ContentType: "application/json",
+			Directives: CacheDirectives{Public: true, MaxAge: time.Minute, SMaxAge: 5 * time.Minute,
+				StaleWhileRevalidate: time.Minute, SurrogateMaxAge: time.Hour}},
+		CacheRule{ContentType: "image/", Directives: CacheDirectives{Public: true, MaxAge: time.Hour}},
+		CacheRule{ContentType: "text/html", Directives: CacheDirectives{Private: true, NoCache: true}},
+	)
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	policy.now = func() time.Time { return now }
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.URL.Query().Get("ct"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		if cc := r.URL.Query().Get("cc"); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	h := policy.Handler(handler)
+
+	tbl := []struct {
+		url, cc, expires, surrogate string
+	}{
+		{"/static/app.js", "public, immutable, max-age=86400", "Mon, 02 Mar 2026 10:00:00 GMT", ""},
+		{"/api/items?ct=application/json%3B+charset=utf-8",
+			"public, max-age=60, s-maxage=300, stale-while-revalidate=60", "Sun, 01 Mar 2026 10:01:00 GMT", "max-age=3600"},
+		{"/api/items?ct=text/plain", "", "", ""},
+		{"/img/logo?ct=image/png", "public, max-age=3600", "Sun, 01 Mar 2026 11:00:00 GMT", ""},
+		{"/page?ct=text/html%3B+charset=utf-8", "private, no-cache", "", ""},
+		{"/static/app.js?cc=no-store", "no-store", "", ""},
+		{"/static/app.js?fail=1", "", "", ""},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tt.url, http.NoBody))
+			assert.Equal(t, "ok", w.Body.String())
+			assert.Equal(t, tt.cc, w.Header().Get("Cache-Control"))
+			assert.Equal(t, tt.expires, w.Header().Get("Expires"))
+			assert.Equal(t, tt.surrogate, w.Header().Get("Surrogate-Control"))
+		})
+	}
+}
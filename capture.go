@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+)
+
+// capturedResponse is a complete response recorded by captureWriter, to be kept and sent later, maybe many times
+type capturedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// replay sends the response to w. Headers already set on w are kept unless the response has them too.
+func (c *capturedResponse) replay(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range c.header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body)
+}
+
+// size is the approximate memory taken by the response
+func (c *capturedResponse) size() int64 {
+	res := int64(len(c.body))
+	for k, v := range c.header {
+		res += int64(len(k))
+		for _, s := range v {
+			res += int64(len(s))
+		}
+	}
+	return res
+}
+
+// captureWriter records a response instead of sending it. Informational (1xx) statuses are dropped,
+// they can't be replayed.
+type captureWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newCaptureWriter() *captureWriter {
+	return &captureWriter{header: http.Header{}, status: http.StatusOK}
+}
+
+// Header returns the header map of the recorded response
+func (c *captureWriter) Header() http.Header {
+	return c.header
+}
+
+// WriteHeader records the status, the first one wins
+func (c *captureWriter) WriteHeader(code int) {
+	if c.wroteHeader || code < 200 {
+		return
+	}
+	c.status, c.wroteHeader = code, true
+}
+
+// Write records the body
+func (c *captureWriter) Write(p []byte) (int, error) {
+	c.wroteHeader = true
+	return c.body.Write(p)
+}
+
+// response returns a copy of the recorded response
+func (c *captureWriter) response() *capturedResponse {
+	return &capturedResponse{status: c.status, header: c.header.Clone(), body: bytes.Clone(c.body.Bytes())}
+}
+
+// flightGroup runs a function once per key for concurrent callers, all of them getting its result
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done     chan struct{}
+	val      T
+	panicked bool
+}
+
+// do runs fn unless a call for the key is in flight already, in which case it waits for that call and
+// returns its result with shared set. If fn panics, the panic goes on in the caller running it, while the
+// callers waiting get ok unset and are on their own.
+func (g *flightGroup[T]) do(key string, fn func() T) (val T, shared, ok bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	if c, found := g.calls[key]; found {
+		g.mu.Unlock()
+		<-c.done
+		return c.val, true, !c.panicked
+	}
+	c := &flightCall[T]{done: make(chan struct{}), panicked: true}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val = fn()
+	c.panicked = false
+	return c.val, false, true
+}
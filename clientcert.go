@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// AuthMTLS is the Method of principals authenticated by ClientCert
+const AuthMTLS = "mtls"
+
+const clientCertContextKey = "clientCert"
+
+// CertRule matches a client certificate. All the non-empty fields have to match, and a rule with all the fields
+// empty matches nothing. CommonName, DNSName and URI are path.Match patterns, so "*" doesn't match "/" but does
+// match dots, and an invalid pattern matches nothing.
+type CertRule struct {
+	CommonName  string // subject CN, like "billing" or "billing-*"
+	DNSName     string // any of SAN DNS names, like "*.svc.cluster.local"
+	URI         string // any of SAN URIs, like SPIFFE ID "spiffe://example.org/ns/prod/sa/*"
+	Fingerprint string // hex sha256 of the certificate, case and colons ignored
+}
+
+// Match reports whether the certificate matches the rule
+func (c CertRule) Match(cert *x509.Certificate) bool {
+	if c == (CertRule{}) {
+		return false
+	}
+	if c.CommonName != "" && !certPatternMatch(c.CommonName, cert.Subject.CommonName) {
+		return false
+	}
+	if c.DNSName != "" && !certAnyMatch(c.DNSName, cert.DNSNames) {
+		return false
+	}
+	if c.URI != "" {
+		uris := make([]string, 0, len(cert.URIs))
+		for _, u := range cert.URIs {
+			uris = append(uris, u.String())
+		}
+		if !certAnyMatch(c.URI, uris) {
+			return false
+		}
+	}
+	if c.Fingerprint != "" && strings.ToLower(strings.ReplaceAll(c.Fingerprint, ":", "")) != CertFingerprint(cert) {
+		return false
+	}
+	return true
+}
+
+// ClientCert middleware authorizes requests by the client certificate of mutual TLS. The server has to verify
+// client certificates, with tls.Config ClientCAs and ClientAuth of tls.RequireAndVerifyClientCert or
+// tls.VerifyClientCertIfGiven, and the middleware accepts verified certificates only. A request without one gets
+// StatusUnauthorized (401), a certificate matching none of the rules gets StatusForbidden (403); with no rules
+// any verified certificate is accepted. It panics on a rule with all the fields empty, likely a misconfiguration.
+// The certificate goes to the context, see ClientCertificate, so does the principal, see GetPrincipal, with
+// the first SAN URI, like a SPIFFE ID, as the username, or the subject CN, or the first SAN DNS name, and
+// the request is marked authorized the same way BasicAuth does it, see IsAuthorized.
+func ClientCert(rules ...CertRule) func(http.Handler) http.Handler {
+	if slices.Contains(rules, CertRule{}) {
+		panic("rest: ClientCert rule with all the fields empty")
+	}
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "client certificate required"})
+				return
+			}
+			cert := r.TLS.PeerCertificates[0]
+			if len(rules) > 0 && !certRulesMatch(rules, cert) {
+				_ = EncodeJSON(w, http.StatusForbidden, JSON{"error": "client certificate not allowed"})
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextKey(clientCertContextKey), cert))
+			h.ServeHTTP(w, authorized(r, Principal{Username: certIdentity(cert), Method: AuthMTLS}))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ClientCertificate returns the client certificate authorized by ClientCert, false if there is none
+func ClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(contextKey(clientCertContextKey)).(*x509.Certificate)
+	return cert, ok
+}
+
+// CertFingerprint returns lowercase hex sha256 of the certificate, as used by CertRule
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func certRulesMatch(rules []CertRule, cert *x509.Certificate) bool {
+	for _, rule := range rules {
+		if rule.Match(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+func certAnyMatch(pattern string, values []string) bool {
+	for _, v := range values {
+		if certPatternMatch(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func certPatternMatch(pattern, value string) bool {
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
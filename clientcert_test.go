@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test ca"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testCA{cert: cert, key: key}
+}
+
+// issue makes a client certificate with the CN, dns names and uris
+func (ca testCA) issue(t *testing.T, cn string, dns []string, uris ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: cn},
+		DNSNames: dns, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	for _, u := range uris {
+		parsed, parseErr := url.Parse(u)
+		require.NoError(t, parseErr)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestCertRule_Match(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "billing-1", []string{"billing.prod.svc.cluster.local"}, "spiffe://example.org/ns/prod/sa/billing").Leaf
+	fp := CertFingerprint(cert)
+
+	tbl := []struct {
+		name string
+		rule CertRule
+		ok   bool
+	}{
+		{"empty", CertRule{}, false},
+		{"cn", CertRule{CommonName: "billing-1"}, true},
+		{"cn pattern", CertRule{CommonName: "billing-*"}, true},
+		{"cn mismatch", CertRule{CommonName: "orders"}, false},
+		{"dns", CertRule{DNSName: "*.prod.svc.cluster.local"}, true},
+		{"dns mismatch", CertRule{DNSName: "*.stage.svc.cluster.local"}, false},
+		{"spiffe", CertRule{URI: "spiffe://example.org/ns/prod/sa/billing"}, true},
+		{"spiffe pattern", CertRule{URI: "spiffe://example.org/ns/prod/sa/*"}, true},
+		{"spiffe star doesn't cross segments", CertRule{URI: "spiffe://example.org/ns/*"}, false},
+		{"fingerprint", CertRule{Fingerprint: fp}, true},
+		{"fingerprint with colons", CertRule{Fingerprint: strings.ToUpper(fp[:2] + ":" + fp[2:])}, true},
+		{"fingerprint mismatch", CertRule{Fingerprint: strings.Repeat("0", 64)}, false},
+		{"all match", CertRule{CommonName: "billing-1", URI: "spiffe://example.org/ns/prod/sa/*"}, true},
+		{"one mismatch", CertRule{CommonName: "billing-1", URI: "spiffe://example.org/ns/stage/sa/*"}, false},
+		{"bad pattern", CertRule{CommonName: "billing-["}, false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.ok, tt.rule.Match(cert), tt.name)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	billing := ca.issue(t, "billing", nil, "spiffe://example.org/ns/prod/sa/billing")
+	orders := ca.issue(t, "orders", []string{"orders.internal"})
+	other := newTestCA(t).issue(t, "billing", nil, "spiffe://example.org/ns/prod/sa/billing")
+
+	h := ClientCert(CertRule{URI: "spiffe://example.org/ns/prod/sa/*"}, CertRule{CommonName: "orders"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert, ok := ClientCertificate(r.Context())
+			require.True(t, ok)
+			p, ok := GetPrincipal(r.Context())
+			require.True(t, ok)
+			assert.Equal(t, AuthMTLS, p.Method)
+			assert.True(t, IsAuthorized(r.Context()))
+			_, _ = w.Write([]byte(p.Username + " " + cert.Subject.CommonName))
+		}))
+
+	srv := httptest.NewUnstartedServer(h)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven, MinVersion: tls.VersionTLS12}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // handshake of the cert from another ca fails
+	srv.StartTLS()
+	defer srv.Close()
+
+	call := func(cert *tls.Certificate) (int, string) {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		defer transport.CloseIdleConnections()
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := call(&billing)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "spiffe://example.org/ns/prod/sa/billing billing", body)
+	code, body = call(&orders)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "orders orders", body)
+
+	code, body = call(nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.JSONEq(t, `{"error":"client certificate required"}`, body)
+
+	code, _ = call(&other)
+	assert.Zero(t, code, "cert of another ca rejected by the handshake")
+
+	denied := ca.issue(t, "reports", []string{"reports.internal"})
+	code, body = call(&denied)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.JSONEq(t, `{"error":"client certificate not allowed"}`, body)
+}
+
+func TestClientCert_EmptyRule(t *testing.T) {
+	assert.Panics(t, func() { ClientCert(CertRule{CommonName: "billing"}, CertRule{}) })
+	assert.NotPanics(t, func() { ClientCert() })
+}
+
+func TestClientCert_Unverified(t *testing.T) {
+	cert := newTestCA(t).issue(t, "billing", nil)
+	h := ClientCert()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { t.Fatal("should not be called") }))
+
+	req := httptest.NewRequest("GET", "/", http.NoBody)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}} // tls.RequestClientCert
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest("GET", "/", http.NoBody)
+	req.TLS = nil
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "plain http")
+}
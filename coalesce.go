@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Coalesce middleware collapses concurrent identical GET requests into a single handler run, sending its
+// response to all of them. Requests are identical when their url and the values of the given headers match.
+// Requests with Authorization or Cookie are passed as they are, unless these headers are part of the key,
+// as their responses are likely personal. A response not meant to be shared, one with a status not cacheable,
+// like 5xx, Set-Cookie or Cache-Control no-store or private, or one made for a request canceled meanwhile, is only
+// sent to the request it was made for, the others run the handler on their own. Responses are buffered, so the
+// middleware doesn't fit streaming endpoints.
+func Coalesce(headers ...string) func(http.Handler) http.Handler {
+	keyHeaders := make([]string, len(headers))
+	for i, name := range headers {
+		keyHeaders[i] = http.CanonicalHeaderKey(name)
+	}
+	personal := func(r *http.Request) bool {
+		for _, name := range []string{"Authorization", "Cookie"} {
+			if r.Header.Get(name) != "" && !slices.Contains(keyHeaders, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var flights flightGroup[cacheFlight]
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || personal(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			var key strings.Builder
+			key.WriteString(r.Host + r.URL.RequestURI())
+			for _, name := range keyHeaders {
+				key.WriteString("\n" + strings.Join(r.Header.Values(name), ","))
+			}
+
+			res, shared, ok := flights.do(key.String(), func() cacheFlight {
+				cw := newCaptureWriter()
+				h.ServeHTTP(cw, r)
+				resp := cw.response()
+				// a canceled leader likely got an error made for its cancellation
+				return cacheFlight{resp: resp, cacheable: r.Context().Err() == nil && coalesceShareable(resp)}
+			})
+			if shared && (!ok || !res.cacheable) {
+				h.ServeHTTP(w, r) // the leader panicked or got a response for itself only
+				return
+			}
+			res.resp.replay(w)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func coalesceShareable(resp *capturedResponse) bool {
+	if !cacheableStatus(resp.status) || len(resp.header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	cc := parseCacheControl(resp.header.Values("Cache-Control"))
+	for _, d := range []string{"no-store", "private"} {
+		if _, found := cc[d]; found {
+			return false
+		}
+	}
+	return true
+}
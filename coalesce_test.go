@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runConcurrent sends n identical requests while the handler is held, it returns response bodies
+// along with the number of requests which panicked
+func runConcurrent(t *testing.T, h http.Handler, n int, calls *atomic.Int32, release chan struct{},
+	setup func(r *http.Request)) (bodies []string, panics int) {
+	t.Helper()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if rec := recover(); rec != nil {
+					mu.Lock()
+					panics++
+					mu.Unlock()
+				}
+			}()
+			req := httptest.NewRequest("GET", "/hot?x=1", http.NoBody)
+			if setup != nil {
+				setup(req)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			mu.Lock()
+			bodies = append(bodies, w.Body.String())
+			mu.Unlock()
+		}()
+	}
+	require.Eventually(t, func() bool { return calls.Load() >= 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond) // let the rest join the flight
+	close(release)
+	wg.Wait()
+	return bodies, panics
+}
+
+func TestCoalesce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		<-release
+		w.Header().Set("X-Call", fmt.Sprintf("%d", n))
+		_, _ = w.Write([]byte("data"))
+	})
+
+	bodies, _ := runConcurrent(t, Coalesce()(handler), 10, &calls, release, nil)
+	assert.Equal(t, int32(1), calls.Load())
+	require.Len(t, bodies, 10)
+	for _, b := range bodies {
+		assert.Equal(t, "data", b)
+	}
+}
+
+func TestCoalesce_Bypass(t *testing.T) {
+	tbl := []struct {
+		name    string
+		headers []string
+		setup   func(r *http.Request)
+		resp    func(w http.ResponseWriter)
+		calls   int32
+	}{
+		{"authorization", nil, func(r *http.Request) { r.Header.Set("Authorization", "Bearer x") }, nil, 5},
+		{"cookie", nil, func(r *http.Request) { r.Header.Set("Cookie", "a=b") }, nil, 5},
+		{"authorization in key", []string{"authorization"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer x") },
+			nil, 1},
+		{"private response", nil, nil, func(w http.ResponseWriter) { w.Header().Set("Cache-Control", "private") }, 5},
+		{"no-store response", nil, nil, func(w http.ResponseWriter) { w.Header().Set("Cache-Control", "no-store") }, 5},
+		{"cookie response", nil, nil, func(w http.ResponseWriter) { w.Header().Set("Set-Cookie", "s=1") }, 5},
+		{"error response", nil, nil, func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }, 5},
+		{"not found response", nil, nil, func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }, 1},
+		{"public response", nil, nil, func(w http.ResponseWriter) { w.Header().Set("Cache-Control", "public, max-age=5") }, 1},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			release := make(chan struct{})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				<-release
+				if tt.resp != nil {
+					tt.resp(w)
+				}
+				_, _ = w.Write([]byte("data"))
+			})
+			bodies, _ := runConcurrent(t, Coalesce(tt.headers...)(handler), 5, &calls, release, tt.setup)
+			assert.Equal(t, tt.calls, calls.Load())
+			assert.Len(t, bodies, 5)
+		})
+	}
+}
+
+func TestCoalesce_KeyHeaders(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+	h := Coalesce("Accept-Language")(handler)
+
+	for _, lang := range []string{"en", "de"} {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, lang, w.Body.String())
+	}
+	assert.Equal(t, int32(2), calls.Load(), "sequential requests are not coalesced")
+}
+
+func TestCoalesce_LeaderPanics(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		<-release
+		if n == 1 {
+			panic("boom")
+		}
+		_, _ = w.Write([]byte("data"))
+	})
+
+	bodies, panics := runConcurrent(t, Coalesce()(handler), 5, &calls, release, nil)
+	assert.Equal(t, 1, panics, "panic stays with the leader")
+	assert.Equal(t, int32(5), calls.Load(), "waiters run the handler on their own")
+	assert.Len(t, bodies, 4)
+	for _, b := range bodies {
+		assert.Equal(t, "data", b)
+	}
+}
+
+func TestCoalesce_LeaderCanceled(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		if r.Context().Err() != nil {
+			_ = EncodeJSON(w, http.StatusOK, JSON{"error": "canceled"}) // even a status otherwise shared
+			return
+		}
+		_, _ = w.Write([]byte("data"))
+	})
+	h := Coalesce()(handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hot", http.NoBody).WithContext(ctx))
+	}()
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	waiter := httptest.NewRecorder()
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		h.ServeHTTP(waiter, httptest.NewRequest("GET", "/hot", http.NoBody))
+	}()
+	time.Sleep(50 * time.Millisecond) // let the waiter join the flight
+	cancel()
+	close(release)
+	<-leaderDone
+	<-waiterDone
+	assert.Equal(t, http.StatusOK, waiter.Code)
+	assert.Equal(t, "data", waiter.Body.String(), "waiter runs the handler on its own")
+	assert.Equal(t, int32(2), calls.Load())
+}
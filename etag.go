@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag middleware buffers GET and HEAD responses and tags them with a strong ETag made of the body's sha256,
+// unless the handler sets an ETag itself. Conditional headers are then evaluated against the ETag and the
+// handler's Last-Modified, see CheckPreconditions, and answered with StatusNotModified (304) or
+// StatusPreconditionFailed (412) instead of the response. Only successful (2xx) responses are subject to
+// preconditions, others are passed as they are. Other methods go straight to the handler, which is the one
+// to know the current etag of the resource, so it should call CheckPreconditions before changing anything.
+func ETag(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		cw := newCaptureWriter()
+		h.ServeHTTP(cw, r)
+		resp := cw.response()
+		if resp.status < 200 || resp.status > 299 {
+			resp.replay(w)
+			return
+		}
+
+		etag := resp.header.Get("Etag")
+		if etag == "" && (len(resp.body) > 0 || r.Method == http.MethodGet) {
+			sum := sha256.Sum256(resp.body)
+			etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+			resp.header.Set("Etag", etag)
+		}
+		var lastModified time.Time
+		if lm := resp.header.Get("Last-Modified"); lm != "" {
+			lastModified, _ = http.ParseTime(lm)
+		}
+
+		switch status := CheckPreconditions(r, etag, lastModified); status {
+		case http.StatusNotModified:
+			// a 304 carries validators and caching headers, but nothing describing the content it doesn't have
+			for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Language",
+				"Content-Range", "Transfer-Encoding"} {
+				resp.header.Del(k)
+			}
+			resp.status, resp.body = http.StatusNotModified, nil
+			resp.replay(w)
+		case http.StatusPreconditionFailed:
+			renderJSONWithStatus(w, JSON{"error": "precondition failed"}, http.StatusPreconditionFailed)
+		default:
+			resp.replay(w)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// CheckPreconditions evaluates conditional request headers against the current etag and last modification
+// time of the resource, in the order of RFC 9110 section 13.2.2. It returns StatusPreconditionFailed (412)
+// or StatusNotModified (304) for the response to send instead of processing the request, or 0 to go ahead.
+// An empty etag means the resource doesn't exist, zero lastModified that its modification time is unknown.
+// Handlers of unsafe methods should call it before making changes, to implement optimistic locking.
+func CheckPreconditions(r *http.Request, etag string, lastModified time.Time) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	lastModified = lastModified.Truncate(time.Second) // http dates have no finer precision
+
+	if ifMatch := r.Header.Values("If-Match"); len(ifMatch) > 0 {
+		if !preconditionMatch(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(r, "If-Unmodified-Since"); ok && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Values("If-None-Match"); len(ifNoneMatch) > 0 {
+		if preconditionMatch(ifNoneMatch, etag, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(r, "If-Modified-Since"); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// preconditionMatch reports whether the If-Match or If-None-Match field values match the etag. "*" matches
+// any existing resource. Strong comparison, required by If-Match, never matches a weak etag.
+func preconditionMatch(values []string, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, v := range values {
+		for tag := range strings.SplitSeq(v, ",") {
+			tag = strings.TrimSpace(tag)
+			switch {
+			case tag == "*":
+				return true
+			case strong && strings.HasPrefix(tag, "W/"):
+				continue
+			case strings.TrimPrefix(tag, "W/") == opaque:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerTime parses an http date header, ok is unset for a missing or invalid one, which is to be ignored
+func headerTime(r *http.Request, name string) (time.Time, bool) {
+	v := r.Header.Get(name)
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(v)
+	return t, err == nil
+}
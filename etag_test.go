@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	lm := time.Date(2026, 3, 1, 10, 0, 0, 500, time.UTC)
+	before, after := lm.Add(-time.Hour).Format(http.TimeFormat), lm.Add(time.Hour).Format(http.TimeFormat)
+	exact := lm.Format(http.TimeFormat)
+
+	tbl := []struct {
+		name    string
+		method  string
+		headers []string
+		etag    string
+		res     int
+	}{
+		{"no conditions", "GET", nil, `"a"`, 0},
+		{"if-match matches", "PUT", []string{"If-Match", `"x", "a"`}, `"a"`, 0},
+		{"if-match differs", "PUT", []string{"If-Match", `"x"`}, `"a"`, 412},
+		{"if-match weak tag", "PUT", []string{"If-Match", `W/"a"`}, `"a"`, 412},
+		{"if-match weak etag", "PUT", []string{"If-Match", `"a"`}, `W/"a"`, 412},
+		{"if-match star", "PUT", []string{"If-Match", "*"}, `"a"`, 0},
+		{"if-match star no resource", "PUT", []string{"If-Match", "*"}, "", 412},
+		{"if-match over if-unmodified-since", "PUT", []string{"If-Match", `"a"`, "If-Unmodified-Since", before}, `"a"`, 0},
+		{"if-unmodified-since passes", "PUT", []string{"If-Unmodified-Since", exact}, `"a"`, 0},
+		{"if-unmodified-since fails", "PUT", []string{"If-Unmodified-Since", before}, `"a"`, 412},
+		{"if-unmodified-since invalid", "PUT", []string{"If-Unmodified-Since", "yesterday"}, `"a"`, 0},
+		{"if-none-match matches get", "GET", []string{"If-None-Match", `W/"a"`}, `"a"`, 304},
+		{"if-none-match matches head", "HEAD", []string{"If-None-Match", `"a"`}, `"a"`, 304},
+		{"if-none-match matches put", "PUT", []string{"If-None-Match", `"a"`}, `"a"`, 412},
+		{"if-none-match star put", "PUT", []string{"If-None-Match", "*"}, `"a"`, 412},
+		{"if-none-match star create", "PUT", []string{"If-None-Match", "*"}, "", 0},
+		{"if-none-match differs", "GET", []string{"If-None-Match", `"x"`}, `"a"`, 0},
+		{"if-none-match over if-modified-since", "GET", []string{"If-None-Match", `"x"`, "If-Modified-Since", after}, `"a"`, 0},
+		{"if-modified-since not modified", "GET", []string{"If-Modified-Since", exact}, `"a"`, 304},
+		{"if-modified-since modified", "GET", []string{"If-Modified-Since", before}, `"a"`, 0},
+		{"if-modified-since ignored for put", "PUT", []string{"If-Modified-Since", after}, `"a"`, 0},
+		{"if-match checked before if-none-match", "GET", []string{"If-Match", `"x"`, "If-None-Match", `"a"`}, `"a"`, 412},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/res", http.NoBody)
+			for i := 0; i < len(tt.headers); i += 2 {
+				req.Header.Set(tt.headers[i], tt.headers[i+1])
+			}
+			assert.Equal(t, tt.res, CheckPreconditions(req, tt.etag, lm))
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	body := "some content"
+	lm := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		case "/own":
+			w.Header().Set("Etag", `W/"v1"`)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", lm.Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write([]byte(body))
+	})
+	h := ETag(handler)
+
+	send := func(method, path string, hdrs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, http.NoBody)
+		for i := 0; i < len(hdrs); i += 2 {
+			req.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "/res")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("Etag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, body, w.Body.String())
+	assert.Equal(t, etag, send("GET", "/res").Header().Get("Etag"), "same content, same etag")
+
+	w = send("GET", "/res", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("Etag"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("Content-Type"), "content headers dropped from 304")
+
+	w = send("GET", "/res", "If-Modified-Since", lm.Format(http.TimeFormat))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = send("GET", "/res", "If-Match", `"other"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `{"error":"precondition failed"}`+"\n", w.Body.String())
+
+	w = send("GET", "/own", "If-None-Match", `"v1"`)
+	assert.Equal(t, http.StatusNotModified, w.Code, "handler's etag used")
+	assert.Equal(t, `W/"v1"`, w.Header().Get("Etag"))
+
+	w = send("GET", "/missing", "If-Match", `"x"`)
+	assert.Equal(t, http.StatusNotFound, w.Code, "preconditions apply to successful responses only")
+	assert.Empty(t, w.Header().Get("Etag"))
+
+	w = send("POST", "/res", "If-Match", `"x"`)
+	assert.Equal(t, http.StatusOK, w.Code, "other methods passed to the handler")
+	assert.Empty(t, w.Header().Get("Etag"))
+}
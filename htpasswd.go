@@ -0,0 +1,280 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"  //nolint:gosec // apr1 is a legacy htpasswd format, supported for existing files
+	"crypto/sha1" //nolint:gosec // {SHA} is a legacy htpasswd format, supported for existing files
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HtpasswdConfig defines how Htpasswd watches its file
+type HtpasswdConfig struct {
+	// Interval is the shortest time between checks of the file for changes. Default: 10s
+	Interval time.Duration
+	// OnError is called with the error of a failed reload, the last good users stay in use. Default: none
+	OnError func(err error)
+}
+
+// HtpasswdOpt is a functional option for Htpasswd
+type HtpasswdOpt func(*HtpasswdConfig)
+
+// HtpasswdInterval sets the shortest time between checks of the file for changes
+func HtpasswdInterval(d time.Duration) HtpasswdOpt {
+	return func(c *HtpasswdConfig) {
+		c.Interval = d
+	}
+}
+
+// HtpasswdOnError sets a function called with reload errors, like a missing file or an invalid line
+func HtpasswdOnError(fn func(err error)) HtpasswdOpt {
+	return func(c *HtpasswdConfig) {
+		c.OnError = fn
+	}
+}
+
+// Htpasswd is a set of users loaded from an Apache htpasswd file, "user:hash" per line, "#" starting a comment.
+// Supported hashes are bcrypt ($2y$, from htpasswd -B), SHA1 ({SHA}, htpasswd -s), apr1 MD5 ($apr1$, htpasswd -m)
+// and argon2id in PHC format ($argon2id$v=19$m=65536,t=1,p=4$salt$hash, see FormatArgon2Hash). Like IPList, the
+// file is checked for changes as it is used, at most once per interval, and a file failing to load is reported
+// while the last good users stay in use.
+type Htpasswd struct {
+	file    *watchedFile
+	users   atomic.Pointer[map[string]string]
+	onError func(err error)
+}
+
+// NewHtpasswd loads users from the file, failing if it can't be loaded
+func NewHtpasswd(path string, opts ...HtpasswdOpt) (*Htpasswd, error) {
+	cfg := HtpasswdConfig{Interval: 10 * time.Second}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	res := &Htpasswd{file: newWatchedFile(path, cfg.Interval), onError: cfg.OnError}
+	if err := res.file.load(res.parse); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Check reports whether the password matches the user's hash, reloading the file first if it changed.
+// It can be used as the checker of BasicAuth.
+func (h *Htpasswd) Check(user, passwd string) bool {
+	if err := h.file.poll(h.parse); err != nil && h.onError != nil {
+		h.onError(err)
+	}
+	hash, ok := (*h.users.Load())[user]
+	if !ok {
+		return false
+	}
+	return checkHtpasswdHash(hash, passwd)
+}
+
+func (h *Htpasswd) parse(data []byte) error {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("line %d: expected user:hash", num)
+		}
+		if _, dup := users[user]; dup {
+			return fmt.Errorf("line %d: duplicate user %q", num, user)
+		}
+		if !htpasswdHashSupported(hash) {
+			return fmt.Errorf("line %d: unsupported hash of user %q", num, user)
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	h.users.Store(&users)
+	return nil
+}
+
+// BasicAuthWithHtpasswd middleware requires basic auth and checks user & passwd against the htpasswd users.
+// It responds like BasicAuth, and puts the authenticated user to the context, see GetPrincipal and BasicAuthUser.
+func BasicAuthWithHtpasswd(users *Htpasswd) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !users.Check(u, p) {
+				reportAuthFailure(r)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, basicAuthorized(r, u))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// FormatArgon2Hash makes an htpasswd argon2id hash in PHC format from the base64 hash and salt of GenerateArgon2Hash
+func FormatArgon2Hash(hash, salt string) (string, error) {
+	hashBytes, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		return "", fmt.Errorf("invalid hash: %w", err)
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("invalid salt: %w", err)
+	}
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 64*1024, 1, 4,
+		base64.RawStdEncoding.EncodeToString(saltBytes), base64.RawStdEncoding.EncodeToString(hashBytes)), nil
+}
+
+func htpasswdHashSupported(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		b, err := base64.StdEncoding.DecodeString(hash[len("{SHA}"):])
+		return err == nil && len(b) == sha1.Size
+	case strings.HasPrefix(hash, "$apr1$"):
+		return strings.Count(hash, "$") == 3
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, _, _, ok := parseArgon2PHC(hash)
+		return ok
+	}
+	return false
+}
+
+func checkHtpasswdHash(hash, passwd string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(passwd)) //nolint:gosec // legacy htpasswd format
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash, "$", 4)[2]
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1Hash(passwd, salt))) == 1
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, ok := parseArgon2PHC(hash)
+		if !ok {
+			return false
+		}
+		computed := argon2.IDKey([]byte(passwd), salt, params[1], params[0], uint8(params[2]), uint32(len(key)))
+		return subtle.ConstantTimeCompare(computed, key) == 1
+	}
+	return false
+}
+
+// parseArgon2PHC parses "$argon2id$v=19$m=65536,t=1,p=4$salt$hash" into memory, time and threads, salt and key
+func parseArgon2PHC(s string) (params [3]uint32, salt, key []byte, ok bool) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return params, nil, nil, false
+	}
+	fields := strings.Split(parts[3], ",")
+	if len(fields) != 3 {
+		return params, nil, nil, false
+	}
+	for i, name := range []string{"m=", "t=", "p="} {
+		if !strings.HasPrefix(fields[i], name) {
+			return params, nil, nil, false
+		}
+		v, err := strconv.ParseUint(fields[i][len(name):], 10, 32)
+		if err != nil || v == 0 {
+			return params, nil, nil, false
+		}
+		params[i] = uint32(v)
+	}
+	if params[2] > 255 {
+		return params, nil, nil, false
+	}
+	var err error
+	if salt, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[4], "=")); err != nil {
+		return params, nil, nil, false
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[5], "=")); err != nil || len(key) == 0 {
+		return params, nil, nil, false
+	}
+	return params, salt, key, true
+}
+
+// apr1Hash is Apache's variant of MD5-crypt, "$apr1$salt$hash"
+func apr1Hash(passwd, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw, sl := []byte(passwd), []byte(salt)
+
+	alt := md5.New() //nolint:gosec // legacy htpasswd format
+	alt.Write(pw)
+	alt.Write(sl)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New() //nolint:gosec // legacy htpasswd format
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write(sl)
+	for i := len(pw); i > 0; i -= 16 {
+		ctx.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+
+	for i := range 1000 {
+		round := md5.New() //nolint:gosec // legacy htpasswd format
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write(sl)
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(pw)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, idx := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[idx[0]])<<16|uint32(sum[idx[1]])<<8|uint32(sum[idx[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return magic + salt + "$" + out.String()
+}
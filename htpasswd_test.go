@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestApr1Hash(t *testing.T) {
+	// reference values made with openssl passwd -apr1
+	assert.Equal(t, "$apr1$r31.LMDK$YzQnRrPqeUHFHU8D/Vku9/", apr1Hash("secret", "r31.LMDK"))
+	assert.Equal(t, "$apr1$abc$zkQG0p/nsY.MO9dHfA7U81", apr1Hash("a much longer password, over 16 bytes", "abc"))
+	assert.Equal(t, "$apr1$12345678$zbBEMgfXu4mAHPrplrtNt.", apr1Hash("x", "123456789"))
+}
+
+func TestHtpasswd(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bpass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	argonHash, argonSalt, err := GenerateArgon2Hash("apass")
+	require.NoError(t, err)
+	argonPHC, err := FormatArgon2Hash(argonHash, argonSalt)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	content := "# operators\n" +
+		"bob:" + string(bcryptHash) + "\n" +
+		"sam:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+		"\n" +
+		"amy:$apr1$r31.LMDK$YzQnRrPqeUHFHU8D/Vku9/\n" +
+		"ann:" + argonPHC + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	users, err := NewHtpasswd(path)
+	require.NoError(t, err)
+
+	tbl := []struct {
+		user, passwd string
+		ok           bool
+	}{
+		{"bob", "bpass", true},
+		{"bob", "bad", false},
+		{"sam", "secret", true},
+		{"sam", "Secret", false},
+		{"amy", "secret", true},
+		{"amy", "secret1", false},
+		{"ann", "apass", true},
+		{"ann", "bpass", false},
+		{"nobody", "secret", false},
+		{"", "", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.ok, users.Check(tt.user, tt.passwd), tt.user+":"+tt.passwd)
+	}
+}
+
+func TestHtpasswd_Invalid(t *testing.T) {
+	tbl := []struct {
+		content, err string
+	}{
+		{"bob\n", "line 1: expected user:hash"},
+		{"# c\n:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", "line 2: expected user:hash"},
+		{"bob:plain\n", `line 1: unsupported hash of user "bob"`},
+		{"bob:{SHA}short\n", "unsupported hash"},
+		{"bob:$2y$05$short\n", "unsupported hash"},
+		{"bob:$argon2id$v=19$m=0,t=1,p=4$c2FsdA$aGFzaA\n", "unsupported hash"},
+		{"bob:$argon2id$v=16$m=65536,t=1,p=4$c2FsdA$aGFzaA\n", "unsupported hash"},
+		{"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", `line 2: duplicate user "bob"`},
+	}
+	for _, tt := range tbl {
+		path := filepath.Join(t.TempDir(), ".htpasswd")
+		require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+		_, err := NewHtpasswd(path)
+		require.Error(t, err, tt.content)
+		assert.Contains(t, err.Error(), tt.err)
+	}
+}
+
+func TestBasicAuthWithHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("sam:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600))
+	var reloadErr error
+	users, err := NewHtpasswd(path, HtpasswdInterval(time.Minute), HtpasswdOnError(func(err error) { reloadErr = err }))
+	require.NoError(t, err)
+	now := time.Now()
+	users.file.now = func() time.Time { return now }
+
+	h := BasicAuthWithHtpasswd(users)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, IsAuthorized(r.Context()))
+		_, _ = w.Write([]byte(BasicAuthUser(r.Context())))
+	}))
+	call := func(user, passwd string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		if user != "" {
+			req.SetBasicAuth(user, passwd)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("sam", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "sam", w.Body.String())
+	assert.Equal(t, http.StatusForbidden, call("sam", "bad").Code)
+	assert.Equal(t, http.StatusUnauthorized, call("", "").Code)
+
+	// new operator added, picked up after the interval
+	require.NoError(t, os.WriteFile(path, []byte("sam:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\namy:$apr1$r31.LMDK$YzQnRrPqeUHFHU8D/Vku9/\n"), 0o600))
+	assert.Equal(t, http.StatusForbidden, call("amy", "secret").Code)
+	now = now.Add(time.Minute)
+	w = call("amy", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "amy", w.Body.String())
+
+	// broken file keeps the last good users
+	require.NoError(t, os.WriteFile(path, []byte("amy\n"), 0o600))
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, call("sam", "secret").Code)
+	require.Error(t, reloadErr)
+	assert.Contains(t, reloadErr.Error(), "line 1: expected user:hash")
+
+	assert.Empty(t, BasicAuthUser(httptest.NewRequest("GET", "/", http.NoBody).Context()))
+}
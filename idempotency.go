@@ -0,0 +1,259 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is what an IdempotencyStore keeps under a key. Status is 0 while the original request
+// is in flight, the response fields are set once it completes.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// IdempotencyStore keeps idempotency records. Implementations have to be safe for concurrent use, and Reserve
+// has to be atomic, as it is what keeps two concurrent requests with the same key from both running.
+type IdempotencyStore interface {
+	// Reserve stores an in-flight record for the key unless the key is known already. In that case it
+	// returns the existing record and false.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (existing *IdempotencyRecord, reserved bool, err error)
+	// Save replaces the in-flight record of the key with the complete one
+	Save(ctx context.Context, key string, rec IdempotencyRecord, ttl time.Duration) error
+	// Delete removes the key, so the request can be retried
+	Delete(ctx context.Context, key string) error
+}
+
+// IdempotencyConfig defines Idempotency middleware parameters.
+// Use IdempotencyOpt functions to customize.
+type IdempotencyConfig struct {
+	// Store keeps records. Default: in-memory store
+	Store IdempotencyStore
+	// TTL is how long records are kept. Default: 24h
+	TTL time.Duration
+	// Header is the name of the key header. Default: Idempotency-Key
+	Header string
+	// Methods are the methods the middleware applies to. Default: POST, PATCH
+	Methods []string
+	// Required makes requests without a key fail with StatusBadRequest (400). Default: false, passed as is
+	Required bool
+	// ScopeFn returns the scope of the key, like the user id, so different clients can't replay each other's
+	// responses by reusing a key. Default: nil, keys are global
+	ScopeFn func(r *http.Request) string
+}
+
+// IdempotencyOpt is a functional option for IdempotencyConfig
+type IdempotencyOpt func(*IdempotencyConfig)
+
+// IdempotencyStoreWith sets the store of records
+func IdempotencyStoreWith(store IdempotencyStore) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.Store = store
+	}
+}
+
+// IdempotencyTTL sets how long records are kept
+func IdempotencyTTL(ttl time.Duration) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.TTL = ttl
+	}
+}
+
+// IdempotencyHeader sets the name of the key header
+func IdempotencyHeader(name string) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.Header = name
+	}
+}
+
+// IdempotencyMethods sets the methods the middleware applies to
+func IdempotencyMethods(methods ...string) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.Methods = methods
+	}
+}
+
+// IdempotencyRequired makes the key header mandatory
+func IdempotencyRequired(required bool) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.Required = required
+	}
+}
+
+// IdempotencyScope sets the function returning the scope of keys
+func IdempotencyScope(fn func(r *http.Request) string) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.ScopeFn = fn
+	}
+}
+
+// Idempotency middleware implements the Idempotency-Key header for safe retries of non-idempotent requests.
+// The first response for a key is stored and replayed, with "Idempotent-Replayed: true", to retries with the
+// same key. A retry arriving while the original request is in flight gets StatusConflict (409), a key reused
+// for a different request, by method, url or body, gets StatusUnprocessableEntity (422). Server errors (5xx)
+// aren't stored, so requests failing that way can be retried. The request body is read into memory to
+// fingerprint it.
+func Idempotency(opts ...IdempotencyOpt) func(http.Handler) http.Handler {
+	cfg := IdempotencyConfig{TTL: 24 * time.Hour, Header: "Idempotency-Key", Methods: []string{"POST", "PATCH"}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryIdempotencyStore()
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(cfg.Methods, r.Method) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(cfg.Header)
+			if key == "" {
+				if cfg.Required {
+					renderJSONWithStatus(w, JSON{"error": "missing " + cfg.Header + " header"}, http.StatusBadRequest)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				renderJSONWithStatus(w, JSON{"error": cfg.Header + " is too long"}, http.StatusBadRequest)
+				return
+			}
+			if cfg.ScopeFn != nil {
+				key = cfg.ScopeFn(r) + ":" + key
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				renderJSONWithStatus(w, JSON{"error": "can't read request body"}, http.StatusBadRequest)
+				return
+			}
+			_ = r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			fingerprint := idempotencyFingerprint(r, body)
+
+			existing, reserved, err := cfg.Store.Reserve(r.Context(), key, fingerprint, cfg.TTL)
+			if err != nil {
+				renderJSONWithStatus(w, JSON{"error": "idempotency store failed"}, http.StatusInternalServerError)
+				return
+			}
+			if !reserved {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					renderJSONWithStatus(w, JSON{"error": cfg.Header + " was used for a different request"},
+						http.StatusUnprocessableEntity)
+				case existing.Status == 0:
+					w.Header().Set("Retry-After", "1")
+					renderJSONWithStatus(w, JSON{"error": "request with this " + cfg.Header + " is in progress"},
+						http.StatusConflict)
+				default:
+					w.Header().Set("Idempotent-Replayed", "true")
+					resp := capturedResponse{status: existing.Status, header: existing.Header, body: existing.Body}
+					resp.replay(w)
+				}
+				return
+			}
+
+			// the key is released if the handler panics, the panic itself is left to the recoverer
+			completed := false
+			defer func() {
+				if !completed {
+					_ = cfg.Store.Delete(context.WithoutCancel(r.Context()), key)
+				}
+			}()
+
+			cw := newCaptureWriter()
+			h.ServeHTTP(cw, r)
+			resp := cw.response()
+			completed = true
+
+			ctx := context.WithoutCancel(r.Context()) // the client going away doesn't make the outcome any less real
+			if resp.status >= 500 {
+				_ = cfg.Store.Delete(ctx, key)
+			} else {
+				rec := IdempotencyRecord{Fingerprint: fingerprint, Status: resp.status, Header: resp.header, Body: resp.body}
+				if err := cfg.Store.Save(ctx, key, rec, cfg.TTL); err != nil {
+					_ = cfg.Store.Delete(ctx, key)
+				}
+			}
+			resp.replay(w)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// idempotencyFingerprint identifies the request a key was used for
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	hh := sha256.New()
+	_, _ = io.WriteString(hh, r.Method+" "+r.URL.RequestURI()+"\n")
+	_, _ = hh.Write(body)
+	return hex.EncodeToString(hh.Sum(nil))
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Expired records are swept out as new ones come in.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	rec     IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore makes an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]memoryIdempotencyEntry), now: time.Now}
+}
+
+// Reserve stores an in-flight record for the key unless the key is known already
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, key, fingerprint string,
+	ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.records {
+			if now.After(e.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.records[key]; ok && !now.After(e.expires) {
+		rec := e.rec
+		return &rec, false, nil
+	}
+	s.records[key] = memoryIdempotencyEntry{rec: IdempotencyRecord{Fingerprint: fingerprint}, expires: now.Add(ttl)}
+	return nil, true, nil
+}
+
+// Save replaces the in-flight record of the key with the complete one
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, rec IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryIdempotencyEntry{rec: rec, expires: s.now().Add(ttl)}
+	return nil
+}
+
+// Delete removes the key
+func (s *MemoryIdempotencyStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,174 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Location", fmt.Sprintf("/orders/%d", n))
+		_ = EncodeJSON(w, http.StatusCreated, JSON{"id": n})
+	})
+	h := Idempotency()(handler)
+
+	send := func(method, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/orders", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "k1", `{"item":1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `{"id":1}`+"\n", w.Body.String())
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	w = send("POST", "k1", `{"item":1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `{"id":1}`+"\n", w.Body.String())
+	assert.Equal(t, "/orders/1", w.Header().Get("Location"))
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, int32(1), calls.Load(), "retry is not executed")
+
+	w = send("POST", "k1", `{"item":2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "key reused with another body")
+
+	w = send("POST", "k2", `{"item":1}`)
+	assert.Equal(t, `{"id":2}`+"\n", w.Body.String(), "another key is another request")
+
+	w = send("POST", "", `{"item":1}`)
+	assert.Equal(t, `{"id":3}`+"\n", w.Body.String(), "request without key passed as is")
+
+	w = send("PUT", "k1", `{"item":1}`)
+	assert.Equal(t, `{"id":4}`+"\n", w.Body.String(), "other methods passed as is")
+}
+
+func TestIdempotency_InFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+	h := Idempotency()(handler)
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest("POST", "/jobs", http.NoBody)
+		req.Header.Set("Idempotency-Key", "k")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		done <- w.Code
+	}()
+	<-started
+
+	req := httptest.NewRequest("POST", "/jobs", http.NoBody)
+	req.Header.Set("Idempotency-Key", "k")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusAccepted, <-done)
+}
+
+func TestIdempotency_ServerErrorsAndPanicsNotStored(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			panic("boom")
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	h := Idempotency()(handler)
+
+	send := func() int {
+		req := httptest.NewRequest("POST", "/", http.NoBody)
+		req.Header.Set("Idempotency-Key", "k")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, send())
+	assert.Panics(t, func() { send() })
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestIdempotency_Options(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("User")))
+	})
+	h := Idempotency(IdempotencyHeader("X-Request-Key"), IdempotencyRequired(true), IdempotencyMethods("POST"),
+		IdempotencyScope(func(r *http.Request) string { return r.Header.Get("User") }))(handler)
+
+	send := func(key, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", http.NoBody)
+		req.Header.Set("X-Request-Key", key)
+		req.Header.Set("User", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("", "alice")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"error":"missing X-Request-Key header"}`+"\n", w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, send(strings.Repeat("k", 256), "alice").Code)
+
+	assert.Equal(t, "alice", send("k", "alice").Body.String())
+	assert.Equal(t, "bob", send("k", "bob").Body.String(), "keys are scoped per user")
+	assert.Equal(t, "true", send("k", "bob").Header().Get("Idempotent-Replayed"))
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryIdempotencyStore()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	_, reserved, err := s.Reserve(ctx, "k", "fp", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	existing, reserved, err := s.Reserve(ctx, "k", "fp", time.Hour)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, IdempotencyRecord{Fingerprint: "fp"}, *existing)
+
+	require.NoError(t, s.Save(ctx, "k", IdempotencyRecord{Fingerprint: "fp", Status: 201, Body: []byte("ok")}, time.Hour))
+	existing, _, err = s.Reserve(ctx, "k", "fp", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 201, existing.Status)
+
+	now = now.Add(2 * time.Hour)
+	_, reserved, err = s.Reserve(ctx, "k", "fp", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, reserved, "expired key can be reserved again")
+
+	require.NoError(t, s.Delete(ctx, "k"))
+	_, reserved, err = s.Reserve(ctx, "k", "fp", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+}
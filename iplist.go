@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IPListConfig defines how an IPList watches its file
+type IPListConfig struct {
+	// Interval is the shortest time between checks of the file for changes. Default: 10s
+	Interval time.Duration
+	// OnError is called with the error of a failed reload, the last good list stays in use. Default: none
+	OnError func(err error)
+}
+
+// IPListOpt is a functional option for IPList
+type IPListOpt func(*IPListConfig)
+
+// IPListInterval sets the shortest time between checks of the file for changes
+func IPListInterval(d time.Duration) IPListOpt {
+	return func(c *IPListConfig) {
+		c.Interval = d
+	}
+}
+
+// IPListOnError sets a function called with reload errors, like a missing file or an invalid line
+func IPListOnError(fn func(err error)) IPListOpt {
+	return func(c *IPListConfig) {
+		c.OnError = fn
+	}
+}
+
+// IPList is an IPMatcher loaded from a file with one IP or CIDR per line. Everything after "#" is a comment,
+// blank lines are ignored. The file is checked for changes by its mtime and size as the list is used, at most
+// once per interval, and a changed file is compiled and swapped in atomically. A file failing to load, missing
+// or with an invalid line, is reported and the last good list stays in use.
+type IPList struct {
+	file    *watchedFile
+	set     atomic.Pointer[IPSet]
+	onError func(err error)
+}
+
+// NewIPList loads the list from the file, failing if it can't be loaded
+func NewIPList(path string, opts ...IPListOpt) (*IPList, error) {
+	cfg := IPListConfig{Interval: 10 * time.Second}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	res := &IPList{file: newWatchedFile(path, cfg.Interval), onError: cfg.OnError}
+	if err := res.file.load(res.parse); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Contains reports whether the ip matches any rule of the current list
+func (l *IPList) Contains(ip string) bool {
+	return l.Set().Contains(ip)
+}
+
+// Len returns the number of rules in the current list
+func (l *IPList) Len() int {
+	return l.Set().Len()
+}
+
+// Set returns the current list, reloading it first if the file changed
+func (l *IPList) Set() *IPSet {
+	if err := l.file.poll(l.parse); err != nil && l.onError != nil {
+		l.onError(err)
+	}
+	return l.set.Load()
+}
+
+func (l *IPList) parse(data []byte) error {
+	var rules []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; scanner.Scan(); num++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if _, ok := parseIPRule(line); !ok {
+			return fmt.Errorf("line %d: invalid ip or cidr %q", num, line)
+		}
+		rules = append(rules, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	l.set.Store(NewIPSet(rules...))
+	return nil
+}
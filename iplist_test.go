@@ -0,0 +1,121 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allow.txt")
+	write := func(content string, mtime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	write("# office\n1.1.1.0/24\n\n2.2.2.2 # vpn\n", start)
+
+	var errs []error
+	list, err := NewIPList(path, IPListInterval(time.Minute), IPListOnError(func(err error) { errs = append(errs, err) }))
+	require.NoError(t, err)
+	now := start
+	list.file.now = func() time.Time { return now }
+	list.file.checked.Store(now.UnixNano())
+
+	assert.Equal(t, 2, list.Len())
+	assert.True(t, list.Contains("1.1.1.5"))
+	assert.True(t, list.Contains("2.2.2.2"))
+	assert.False(t, list.Contains("3.3.3.3"))
+
+	write("3.3.3.3\n", start.Add(time.Second))
+	assert.False(t, list.Contains("3.3.3.3"), "not checked before the interval")
+	now = now.Add(time.Minute)
+	assert.True(t, list.Contains("3.3.3.3"), "reloaded")
+	assert.False(t, list.Contains("1.1.1.5"))
+
+	write("4.4.4.4\nnot-an-ip\n", start.Add(2*time.Second))
+	now = now.Add(time.Minute)
+	assert.True(t, list.Contains("3.3.3.3"), "last good list kept")
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), `line 2: invalid ip or cidr "not-an-ip"`)
+	now = now.Add(time.Minute)
+	assert.True(t, list.Contains("3.3.3.3"))
+	assert.Len(t, errs, 1, "broken file reported once")
+
+	require.NoError(t, os.Remove(path))
+	now = now.Add(time.Minute)
+	assert.True(t, list.Contains("3.3.3.3"))
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[1].Error(), "can't stat")
+
+	write("5.5.5.5\n", start.Add(3*time.Second))
+	now = now.Add(time.Minute)
+	assert.True(t, list.Contains("5.5.5.5"))
+	assert.Len(t, errs, 2)
+}
+
+func TestIPList_Errors(t *testing.T) {
+	_, err := NewIPList(filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	require.NoError(t, os.WriteFile(path, []byte("1.2.3.\n"), 0o600))
+	_, err = NewIPList(path)
+	require.Error(t, err, "textual prefixes are not allowed in files")
+}
+
+func TestIPList_Filter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	require.NoError(t, os.WriteFile(path, []byte("1.1.1.1\n"), 0o600))
+	list, err := NewIPList(path, IPListInterval(0))
+	require.NoError(t, err)
+
+	h := IPFilter(IPPolicy{Deny: list})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	check := func(ip string) int {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, check("1.1.1.1"))
+	assert.Equal(t, http.StatusOK, check("2.2.2.2"))
+
+	require.NoError(t, os.WriteFile(path, []byte("2.2.2.2\n# and more\n"), 0o600))
+	assert.Equal(t, http.StatusOK, check("1.1.1.1"))
+	assert.Equal(t, http.StatusForbidden, check("2.2.2.2"))
+}
+
+func TestIPList_EmptyAllowList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allow.txt")
+	require.NoError(t, os.WriteFile(path, []byte("1.2.3.4\n"), 0o600))
+	list, err := NewIPList(path, IPListInterval(0))
+	require.NoError(t, err)
+
+	h := IPFilter(IPPolicy{Allow: list})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	check := func(ip string) int {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, check("1.2.3.4"))
+	assert.Equal(t, http.StatusForbidden, check("9.9.9.9"))
+
+	// truncated by a writer before the new content is written
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	assert.Equal(t, http.StatusForbidden, check("9.9.9.9"), "empty allow list doesn't allow all")
+	assert.Equal(t, http.StatusForbidden, check("1.2.3.4"))
+	assert.Equal(t, 0, list.Len())
+
+	require.NoError(t, os.WriteFile(path, []byte("# nothing yet\n"), 0o600))
+	assert.False(t, IPPolicy{Allow: list}.Allowed("9.9.9.9"))
+	assert.True(t, IPPolicy{Allow: NewIPSet()}.Allowed("9.9.9.9"), "static empty set means no restrictions")
+}
@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// IPMatcher matches source IPs, as strings from realip, against a list of rules. IPSet is a fixed list,
+// IPList one reloaded from a file.
+type IPMatcher interface {
+	Contains(ip string) bool
+	Len() int
+}
+
+// IPSet is a compiled list of ip rules, built once and matched per request. Complete IPs and CIDRs go into
+// a binary prefix trie per address family, so a lookup costs at most one step per address bit however long
+// the list is, like cloud ranges or abuse lists with tens of thousands of networks. Anything else is kept as
+// a textual prefix (like "192.168.") and checked one by one. IPSet is immutable and safe for concurrent use.
+type IPSet struct {
+	v4, v6   *ipTrieNode
+	prefixes []string
+	size     int
+}
+
+// ipTrieNode is a node of a binary trie over address bits; a terminal node covers everything below it
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	terminal bool
+}
+
+// NewIPSet compiles the rules: complete IPs (192.168.1.12), CIDRs (192.168.0.0/16) and textual prefixes (129.168.).
+// Complete IPs use semantic address equality, CIDRs network containment, IPv4-mapped IPv6 is taken as IPv4.
+func NewIPSet(rules ...string) *IPSet {
+	res := &IPSet{v4: &ipTrieNode{}, v6: &ipTrieNode{}}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		res.size++
+		prefix, ok := parseIPRule(rule)
+		if !ok {
+			res.prefixes = append(res.prefixes, rule)
+			continue
+		}
+		root := res.v6
+		if prefix.Addr().Is4() {
+			root = res.v4
+		}
+		root.insert(prefix)
+	}
+	return res
+}
+
+// Len returns the number of rules in the set
+func (s *IPSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.size
+}
+
+// Contains reports whether the ip, as a string from realip, matches any rule of the set.
+// A nil set contains nothing.
+func (s *IPSet) Contains(ip string) bool {
+	if s == nil {
+		return false
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil && s.ContainsAddr(addr) {
+		return true
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(ip, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsAddr reports whether the address is in any complete IP or CIDR of the set, textual prefixes aside
+func (s *IPSet) ContainsAddr(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	root := s.v6
+	if addr.Is4() {
+		root = s.v4
+	}
+	return root.contains(addr)
+}
+
+func (n *ipTrieNode) insert(prefix netip.Prefix) {
+	octets := prefix.Addr().AsSlice()
+	for i := range prefix.Bits() {
+		if n.terminal {
+			return // already covered by a wider network
+		}
+		bit := octets[i/8] >> (7 - i%8) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &ipTrieNode{}
+		}
+		n = n.children[bit]
+	}
+	n.terminal = true
+	n.children = [2]*ipTrieNode{} // everything narrower is covered now
+}
+
+func (n *ipTrieNode) contains(addr netip.Addr) bool {
+	octets := addr.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.terminal {
+			return true
+		}
+		if i == len(octets)*8 {
+			return false
+		}
+		n = n.children[octets[i/8]>>(7-i%8)&1]
+	}
+	return false
+}
+
+// parseIPRule parses a complete IP or a CIDR into a prefix, reporting false for anything else
+func parseIPRule(rule string) (netip.Prefix, bool) {
+	if strings.Contains(rule, "/") {
+		p, err := netip.ParsePrefix(rule)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), true
+	}
+	addr, err := netip.ParseAddr(rule)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPSet(t *testing.T) {
+	set := NewIPSet("10.0.0.0/8", "192.168.1.1", "2001:db8::/32", "::ffff:172.16.0.0/108", "fe80::1", "5.6.", " ", "1.2.3.0/33")
+	assert.Equal(t, 7, set.Len())
+
+	tbl := []struct {
+		ip       string
+		contains bool
+	}{
+		{"10.1.2.3", true},
+		{"11.1.2.3", false},
+		{"192.168.1.1", true},
+		{"192.168.1.10", false},
+		{"::ffff:192.168.1.1", true},
+		{"2001:db8:1::1", true},
+		{"2001:db9::1", false},
+		{"172.16.5.5", true},
+		{"172.32.0.1", false},
+		{"fe80::1%eth0", true},
+		{"5.6.7.8", true},
+		{"1.2.3.0/33", true}, // not a valid cidr, matched as a textual prefix
+		{"bad", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.contains, set.Contains(tt.ip), tt.ip)
+	}
+
+	var nilSet *IPSet
+	assert.False(t, nilSet.Contains("10.1.2.3"))
+	assert.Equal(t, 0, nilSet.Len())
+	assert.False(t, NewIPSet().Contains("10.1.2.3"))
+	assert.True(t, NewIPSet("0.0.0.0/0").Contains("8.8.8.8"))
+	assert.False(t, NewIPSet("0.0.0.0/0").Contains("2001:db8::1"), "families are separate")
+}
+
+func TestIPSet_Overlapping(t *testing.T) {
+	set := NewIPSet("10.1.2.0/24", "10.0.0.0/8", "10.1.2.3")
+	assert.True(t, set.ContainsAddr(netip.MustParseAddr("10.200.0.1")))
+	assert.True(t, set.ContainsAddr(netip.MustParseAddr("10.1.2.200")))
+	assert.False(t, set.ContainsAddr(netip.Addr{}))
+}
+
+func TestIPSet_MatchesLinearScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42)) //nolint:gosec // deterministic test data
+	var rules []netip.Prefix
+	var strs []string
+	for range 2000 {
+		addr := netip.AddrFrom4([4]byte{byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+		p := netip.PrefixFrom(addr, 8+rnd.Intn(25)).Masked()
+		rules = append(rules, p)
+		strs = append(strs, p.String())
+	}
+	set := NewIPSet(strs...)
+
+	for range 20000 {
+		addr := netip.AddrFrom4([4]byte{byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+		expected := false
+		for _, p := range rules {
+			if p.Contains(addr) {
+				expected = true
+				break
+			}
+		}
+		if !assert.Equal(t, expected, set.ContainsAddr(addr), addr.String()) {
+			return
+		}
+	}
+}
+
+func BenchmarkIPSet_Contains(b *testing.B) {
+	rules := make([]string, 0, 50000)
+	for i := range 50000 {
+		rules = append(rules, fmt.Sprintf("%d.%d.%d.0/24", 1+i/65536, i/256%256, i%256))
+	}
+	set := NewIPSet(rules...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Contains("200.1.2.3")
+	}
+}
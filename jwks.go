@@ -0,0 +1,208 @@
+package rest
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+// JWTKey is a key verifying JWT signatures. Key is []byte for HS256, *rsa.PublicKey for RS256,
+// *ecdsa.PublicKey for ES256 and ed25519.PublicKey for EdDSA.
+type JWTKey struct {
+	ID  string // optional, matched with the "kid" of tokens having one
+	Alg string // one of HS256, RS256, ES256, EdDSA
+	Key any
+}
+
+// JWTKeySet provides keys for JWTAuth. Implementations have to be safe for concurrent use.
+type JWTKeySet interface {
+	// Keys returns the keys to try on a token with the kid, empty if the token has none, and the alg
+	Keys(kid, alg string) []JWTKey
+}
+
+// JWTKeys is a static JWTKeySet
+type JWTKeys []JWTKey
+
+// Keys returns the keys with the alg and, if the kid is not empty, with the kid
+func (k JWTKeys) Keys(kid, alg string) []JWTKey {
+	var res []JWTKey
+	for _, key := range k {
+		if key.Alg == alg && (kid == "" || key.ID == kid) {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// JWKSConfig defines how JWKSFile watches its file
+type JWKSConfig struct {
+	// Interval is the shortest time between checks of the file for changes. Default: 10s
+	Interval time.Duration
+	// OnError is called with the error of a failed reload, the last good keys stay in use. Default: none
+	OnError func(err error)
+}
+
+// JWKSOpt is a functional option for JWKSFile
+type JWKSOpt func(*JWKSConfig)
+
+// JWKSInterval sets the shortest time between checks of the file for changes
+func JWKSInterval(d time.Duration) JWKSOpt {
+	return func(c *JWKSConfig) {
+		c.Interval = d
+	}
+}
+
+// JWKSOnError sets a function called with reload errors, like a missing file or an invalid key
+func JWKSOnError(fn func(err error)) JWKSOpt {
+	return func(c *JWKSConfig) {
+		c.OnError = fn
+	}
+}
+
+// JWKSFile is a JWTKeySet loaded from a JSON Web Key Set file (RFC 7517), like the one published by an
+// identity provider and synced to the disk. RSA, EC P-256, Ed25519 and symmetric (oct) keys are loaded,
+// encryption keys ("use":"enc") and keys of other types or algorithms are skipped. Like Htpasswd, the file
+// is checked for changes as it is used, at most once per interval, and a file failing to load is reported
+// while the last good keys stay in use.
+type JWKSFile struct {
+	file    *watchedFile
+	keys    atomic.Pointer[JWTKeys]
+	onError func(err error)
+}
+
+// NewJWKSFile loads keys from the file, failing if it can't be loaded
+func NewJWKSFile(path string, opts ...JWKSOpt) (*JWKSFile, error) {
+	cfg := JWKSConfig{Interval: 10 * time.Second}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	res := &JWKSFile{file: newWatchedFile(path, cfg.Interval), onError: cfg.OnError}
+	if err := res.file.load(res.parse); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Keys returns the keys with the alg and the kid, reloading the file first if it changed
+func (f *JWKSFile) Keys(kid, alg string) []JWTKey {
+	if err := f.file.poll(f.parse); err != nil && f.onError != nil {
+		f.onError(err)
+	}
+	return f.keys.Load().Keys(kid, alg)
+}
+
+func (f *JWKSFile) parse(data []byte) error {
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	f.keys.Store(&keys)
+	return nil
+}
+
+// ParseJWKS parses a JSON Web Key Set, skipping keys which can't verify JWTAuth algorithms
+func ParseJWKS(data []byte) (JWTKeys, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	if set.Keys == nil {
+		return nil, errors.New("invalid jwks: no keys")
+	}
+	res := JWTKeys{}
+	for i, k := range set.Keys {
+		key, ok, err := k.jwtKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d %q: %w", i, k.Kid, err)
+		}
+		if ok {
+			res = append(res, key)
+		}
+	}
+	return res, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// jwtKey converts a supported jwk, false for keys of other types, algorithms or uses
+func (k jwk) jwtKey() (JWTKey, bool, error) {
+	if k.Use == "enc" {
+		return JWTKey{}, false, nil
+	}
+	var res JWTKey
+	switch {
+	case k.Kty == "RSA":
+		n, e := jwkBigInt(k.N), jwkBigInt(k.E)
+		if n == nil || e == nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return JWTKey{}, false, errors.New("invalid rsa key")
+		}
+		res = JWTKey{Alg: "RS256", Key: &rsa.PublicKey{N: n, E: int(e.Int64())}}
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, y := jwkBytes(k.X), jwkBytes(k.Y)
+		if len(x) != 32 || len(y) != 32 {
+			return JWTKey{}, false, errors.New("invalid ec key")
+		}
+		// ecdh validates the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return JWTKey{}, false, errors.New("invalid ec key")
+		}
+		res = JWTKey{Alg: "ES256", Key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}}
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x := jwkBytes(k.X)
+		if len(x) != ed25519.PublicKeySize {
+			return JWTKey{}, false, errors.New("invalid ed25519 key")
+		}
+		res = JWTKey{Alg: "EdDSA", Key: ed25519.PublicKey(x)}
+	case k.Kty == "oct":
+		secret := jwkBytes(k.K)
+		if len(secret) == 0 {
+			return JWTKey{}, false, errors.New("invalid oct key")
+		}
+		res = JWTKey{Alg: "HS256", Key: secret}
+	default:
+		return JWTKey{}, false, nil
+	}
+	if k.Alg != "" && k.Alg != res.Alg {
+		return JWTKey{}, false, nil // like RS512 for an RSA key
+	}
+	res.ID = k.Kid
+	return res, true, nil
+}
+
+func jwkBytes(s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func jwkBigInt(s string) *big.Int {
+	b := jwkBytes(s)
+	if len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
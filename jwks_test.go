@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testJWKS(t *testing.T, k testJWTKeys) []byte {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]any{
+		{"kty": "RSA", "kid": "r1", "use": "sig", "alg": "RS256", "n": b64(k.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "e1", "crv": "P-256", "x": b64(k.ec.X.FillBytes(make([]byte, 32))),
+			"y": b64(k.ec.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "d1", "crv": "Ed25519", "x": b64(k.edPub)},
+		{"kty": "oct", "kid": "h1", "k": b64(k.hmac)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(k.rsa.N.Bytes()), "e": "AQAB"},
+		{"kty": "RSA", "kid": "r512", "alg": "RS512", "n": b64(k.rsa.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+	}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	k := newTestJWTKeys(t)
+	keys, err := ParseJWKS(testJWKS(t, k))
+	require.NoError(t, err)
+	require.Len(t, keys, 4, "enc, RS512 and P-384 keys skipped")
+	assert.Equal(t, JWTKey{ID: "r1", Alg: "RS256", Key: &k.rsa.PublicKey}, keys[0])
+	assert.Equal(t, "ES256", keys[1].Alg)
+	assert.True(t, k.ec.PublicKey.Equal(keys[1].Key))
+	assert.Equal(t, JWTKey{ID: "d1", Alg: "EdDSA", Key: k.edPub}, keys[2])
+	assert.Equal(t, JWTKey{ID: "h1", Alg: "HS256", Key: k.hmac}, keys[3])
+	assert.Len(t, keys.Keys("", "RS256"), 1)
+	assert.Empty(t, keys.Keys("e1", "RS256"))
+
+	tbl := []struct {
+		data, err string
+	}{
+		{`not json`, "invalid jwks"},
+		{`{}`, "invalid jwks: no keys"},
+		{`{"keys":[{"kty":"RSA","kid":"r","n":"","e":"AQAB"}]}`, `key 0 "r": invalid rsa key`},
+		{`{"keys":[{"kty":"EC","crv":"P-256","x":"AAAA","y":"AAAA"}]}`, "invalid ec key"},
+		{`{"keys":[{"kty":"EC","crv":"P-256","x":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) +
+			`","y":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `"}]}`, "invalid ec key"},
+		{`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AAAA"}]}`, "invalid ed25519 key"},
+		{`{"keys":[{"kty":"oct","k":""}]}`, "invalid oct key"},
+	}
+	for _, tt := range tbl {
+		_, err := ParseJWKS([]byte(tt.data))
+		require.Error(t, err, tt.data)
+		assert.Contains(t, err.Error(), tt.err)
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	k := newTestJWTKeys(t)
+	other := newTestJWTKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, testJWKS(t, k), 0o600))
+	var reloadErr error
+	jwks, err := NewJWKSFile(path, JWKSInterval(time.Minute), JWKSOnError(func(err error) { reloadErr = err }))
+	require.NoError(t, err)
+	now := time.Now()
+	jwks.file.now = func() time.Time { return now }
+
+	h := JWTAuth(jwks)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	call := func(key any) int {
+		token := signTestJWT(t, map[string]any{"alg": "ES256", "kid": "e1"},
+			map[string]any{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()}, key)
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call(k.ec))
+	assert.Equal(t, http.StatusUnauthorized, call(other.ec))
+
+	// keys rotated, picked up after the interval
+	require.NoError(t, os.WriteFile(path, testJWKS(t, other), 0o600))
+	assert.Equal(t, http.StatusUnauthorized, call(other.ec))
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, call(other.ec))
+	assert.Equal(t, http.StatusUnauthorized, call(k.ec))
+
+	// broken file keeps the last good keys
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys":`), 0o600))
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, call(other.ec))
+	require.Error(t, reloadErr)
+	assert.Contains(t, reloadErr.Error(), "invalid jwks")
+
+	_, err = NewJWKSFile(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
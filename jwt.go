@@ -0,0 +1,277 @@
+package rest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AuthJWT is the Method of principals authenticated by JWTAuth
+const AuthJWT = "jwt"
+
+const jwtClaimsContextKey = "jwtClaims"
+
+// JWTConfig defines JWTAuth middleware checks.
+// Use JWTOpt functions to customize.
+type JWTConfig struct {
+	// Issuer is the required "iss" claim. Default: empty, not checked
+	Issuer string
+	// Audience lists accepted audiences, the "aud" claim has to have one of them. Default: empty, not checked
+	Audience []string
+	// Leeway is the allowed clock skew for "exp", "nbf" and "iat" claims. Default: 1m
+	Leeway time.Duration
+	// RequireExp rejects tokens without "exp" claim. Default: true
+	RequireExp bool
+}
+
+// JWTOpt is a functional option for JWTConfig
+type JWTOpt func(*JWTConfig)
+
+// JWTIssuer sets the required "iss" claim
+func JWTIssuer(iss string) JWTOpt {
+	return func(c *JWTConfig) {
+		c.Issuer = iss
+	}
+}
+
+// JWTAudience sets accepted audiences
+func JWTAudience(aud ...string) JWTOpt {
+	return func(c *JWTConfig) {
+		c.Audience = aud
+	}
+}
+
+// JWTLeeway sets the allowed clock skew
+func JWTLeeway(d time.Duration) JWTOpt {
+	return func(c *JWTConfig) {
+		c.Leeway = d
+	}
+}
+
+// JWTRequireExp sets whether tokens without "exp" claim are rejected
+func JWTRequireExp(required bool) JWTOpt {
+	return func(c *JWTConfig) {
+		c.RequireExp = required
+	}
+}
+
+// JWTAuth middleware requires a JWT in "Authorization: Bearer <token>" header, signed with HS256, RS256, ES256
+// or EdDSA by one of the keys of the set, and valid by "exp", "nbf", "iat", "iss" and "aud" claims. The algorithm
+// of a token has to match the key's one, so a public key can't be used as an HMAC secret, and "none" is never
+// accepted. A request without a token gets StatusUnauthorized (401) with "WWW-Authenticate: Bearer", an invalid
+// token gets 401 with "WWW-Authenticate: Bearer error="invalid_token"" and the reason in error_description.
+// The claims go to the context, see GetJWTClaims, so does the principal, see GetPrincipal, with the "sub" claim
+// as the username, "roles" claim as roles and "scope" (space separated) or "scp" claim as scopes.
+func JWTAuth(keys JWTKeySet, opts ...JWTOpt) func(http.Handler) http.Handler {
+	cfg := JWTConfig{Leeway: time.Minute, RequireExp: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "token required"})
+				return
+			}
+			claims, err := cfg.verify(keys, strings.TrimSpace(token), time.Now())
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, err.Error()))
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "invalid token", "details": err.Error()})
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextKey(jwtClaimsContextKey), claims))
+			h.ServeHTTP(w, withPrincipal(r, jwtPrincipal(claims)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetJWTClaims returns the claims of the token verified by JWTAuth, false if there is none.
+// Numbers are float64, like from json.Unmarshal to any.
+func GetJWTClaims(ctx context.Context) (map[string]any, bool) {
+	claims, ok := ctx.Value(contextKey(jwtClaimsContextKey)).(map[string]any)
+	return claims, ok
+}
+
+// verify checks the token's signature and claims, returning the claims of a valid one
+func (c JWTConfig) verify(keys JWTKeySet, token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg  string          `json:"alg"`
+		Kid  string          `json:"kid"`
+		Crit json.RawMessage `json:"crit"`
+	}
+	if err := jwtDecodePart(parts[0], &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+	if header.Crit != nil {
+		return nil, errors.New("unsupported crit header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	switch header.Alg {
+	case "HS256", "RS256", "ES256", "EdDSA":
+	default:
+		return nil, errors.New("unsupported alg")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys.Keys(header.Kid, header.Alg) {
+		if key.Alg == header.Alg && jwtVerifySignature(key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]any
+	if err = jwtDecodePart(parts[1], &claims); err != nil || claims == nil {
+		return nil, errors.New("malformed claims")
+	}
+	if err = c.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (c JWTConfig) checkClaims(claims map[string]any, now time.Time) error {
+	numericDate := func(name string) (time.Time, bool, error) {
+		v, ok := claims[name]
+		if !ok {
+			return time.Time{}, false, nil
+		}
+		f, isNum := v.(float64)
+		if !isNum || math.IsNaN(f) || math.IsInf(f, 0) {
+			return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), true, nil
+	}
+
+	exp, ok, err := numericDate("exp")
+	switch {
+	case err != nil:
+		return err
+	case !ok && c.RequireExp:
+		return errors.New("missing exp claim")
+	case ok && !now.Before(exp.Add(c.Leeway)):
+		return errors.New("token expired")
+	}
+	nbf, ok, err := numericDate("nbf")
+	switch {
+	case err != nil:
+		return err
+	case ok && now.Add(c.Leeway).Before(nbf):
+		return errors.New("token not valid yet")
+	}
+	iat, ok, err := numericDate("iat")
+	switch {
+	case err != nil:
+		return err
+	case ok && now.Add(c.Leeway).Before(iat):
+		return errors.New("token issued in the future")
+	}
+
+	if c.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != c.Issuer {
+			return errors.New("invalid issuer")
+		}
+	}
+	if len(c.Audience) > 0 && !slices.ContainsFunc(jwtStrings(claims["aud"]), func(aud string) bool {
+		return slices.Contains(c.Audience, aud)
+	}) {
+		return errors.New("invalid audience")
+	}
+	return nil
+}
+
+func jwtVerifySignature(key JWTKey, signed, sig []byte) bool {
+	switch k := key.Key.(type) {
+	case []byte:
+		if key.Alg != "HS256" {
+			return false
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		if key.Alg != "RS256" {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if key.Alg != "ES256" || len(sig) != 64 || k.Curve.Params().BitSize != 256 {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		return ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case ed25519.PublicKey:
+		if key.Alg != "EdDSA" || len(k) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(k, signed, sig)
+	}
+	return false
+}
+
+func jwtDecodePart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func jwtPrincipal(claims map[string]any) Principal {
+	sub, _ := claims["sub"].(string)
+	p := Principal{Username: sub, Method: AuthJWT, Roles: jwtStrings(claims["roles"])}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else {
+		p.Scopes = jwtStrings(claims["scp"])
+	}
+	return p
+}
+
+// jwtStrings returns a string or an array of strings claim as a slice, skipping non-string values
+func jwtStrings(v any) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []any:
+		var res []string
+		for _, s := range val {
+			if str, ok := s.(string); ok {
+				res = append(res, str)
+			}
+		}
+		return res
+	}
+	return nil
+}
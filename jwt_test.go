@@ -0,0 +1,251 @@
+package rest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signTestJWT makes a token with the header and claims signed by the private key for the alg
+func signTestJWT(t *testing.T, header, claims map[string]any, key any) string {
+	t.Helper()
+	hdr, err := json.Marshal(header)
+	require.NoError(t, err)
+	cl, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(cl)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, sum[:])
+		require.NoError(t, signErr)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case nil:
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+type testJWTKeys struct {
+	hmac  []byte
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	ed    ed25519.PrivateKey
+	edPub ed25519.PublicKey
+}
+
+func newTestJWTKeys(t *testing.T) testJWTKeys {
+	t.Helper()
+	var res testJWTKeys
+	var err error
+	res.hmac = []byte("0123456789abcdef0123456789abcdef")
+	res.rsa, err = rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	res.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	res.edPub, res.ed, err = ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return res
+}
+
+func TestJWTAuth_Algorithms(t *testing.T) {
+	k := newTestJWTKeys(t)
+	keys := JWTKeys{
+		{ID: "h1", Alg: "HS256", Key: k.hmac},
+		{ID: "r1", Alg: "RS256", Key: &k.rsa.PublicKey},
+		{ID: "e1", Alg: "ES256", Key: &k.ec.PublicKey},
+		{ID: "d1", Alg: "EdDSA", Key: k.edPub},
+	}
+	h := JWTAuth(keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetJWTClaims(r.Context())
+		require.True(t, ok)
+		p, ok := GetPrincipal(r.Context())
+		require.True(t, ok)
+		assert.Equal(t, AuthJWT, p.Method)
+		_, _ = w.Write([]byte(p.Username + " " + claims["alg_name"].(string)))
+	}))
+	claims := func(alg string) map[string]any {
+		return map[string]any{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix(), "alg_name": alg}
+	}
+
+	tbl := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256", signTestJWT(t, map[string]any{"alg": "HS256", "kid": "h1"}, claims("HS256"), k.hmac), true},
+		{"rs256", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "r1"}, claims("RS256"), k.rsa), true},
+		{"es256", signTestJWT(t, map[string]any{"alg": "ES256", "kid": "e1"}, claims("ES256"), k.ec), true},
+		{"eddsa", signTestJWT(t, map[string]any{"alg": "EdDSA", "kid": "d1"}, claims("EdDSA"), k.ed), true},
+		{"no kid", signTestJWT(t, map[string]any{"alg": "ES256"}, claims("ES256"), k.ec), true},
+		{"wrong kid", signTestJWT(t, map[string]any{"alg": "HS256", "kid": "r1"}, claims("HS256"), k.hmac), false},
+		{"wrong hmac secret", signTestJWT(t, map[string]any{"alg": "HS256"}, claims("HS256"), []byte("other")), false},
+		{"none", signTestJWT(t, map[string]any{"alg": "none"}, claims("none"), nil), false},
+		{"rsa public key as hmac secret", signTestJWT(t, map[string]any{"alg": "HS256", "kid": "r1"}, claims("HS256"),
+			[]byte(k.rsa.N.String())), false},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if !tt.ok {
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+				assert.True(t, strings.HasPrefix(w.Header().Get("WWW-Authenticate"), `Bearer error="invalid_token"`))
+				return
+			}
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.True(t, strings.HasPrefix(w.Body.String(), "bob "))
+		})
+	}
+}
+
+func TestJWTAuth_Claims(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	keys := JWTKeys{{Alg: "HS256", Key: secret}}
+	h := JWTAuth(keys, JWTIssuer("https://idp.example.com"), JWTAudience("api", "admin-api"), JWTLeeway(30*time.Second))(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("ok")) }))
+	now := time.Now()
+	valid := func(mod func(c map[string]any)) map[string]any {
+		c := map[string]any{"sub": "bob", "iss": "https://idp.example.com", "aud": "api",
+			"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(-time.Minute).Unix(), "iat": now.Add(-time.Minute).Unix()}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+
+	tbl := []struct {
+		name   string
+		claims map[string]any
+		err    string
+	}{
+		{"valid", valid(nil), ""},
+		{"aud array", valid(func(c map[string]any) { c["aud"] = []string{"other", "admin-api"} }), ""},
+		{"expired within leeway", valid(func(c map[string]any) { c["exp"] = now.Add(-10 * time.Second).Unix() }), ""},
+		{"nbf within leeway", valid(func(c map[string]any) { c["nbf"] = now.Add(10 * time.Second).Unix() }), ""},
+		{"expired", valid(func(c map[string]any) { c["exp"] = now.Add(-time.Minute).Unix() }), "token expired"},
+		{"not yet valid", valid(func(c map[string]any) { c["nbf"] = now.Add(time.Minute).Unix() }), "token not valid yet"},
+		{"issued in future", valid(func(c map[string]any) { c["iat"] = now.Add(time.Minute).Unix() }), "token issued in the future"},
+		{"no exp", valid(func(c map[string]any) { delete(c, "exp") }), "missing exp claim"},
+		{"bad exp", valid(func(c map[string]any) { c["exp"] = "tomorrow" }), "invalid exp claim"},
+		{"wrong issuer", valid(func(c map[string]any) { c["iss"] = "https://evil.example.com" }), "invalid issuer"},
+		{"no issuer", valid(func(c map[string]any) { delete(c, "iss") }), "invalid issuer"},
+		{"wrong audience", valid(func(c map[string]any) { c["aud"] = []string{"other"} }), "invalid audience"},
+		{"no audience", valid(func(c map[string]any) { delete(c, "aud") }), "invalid audience"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+signTestJWT(t, map[string]any{"alg": "HS256"}, tt.claims, secret))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if tt.err == "" {
+				assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				return
+			}
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, `Bearer error="invalid_token", error_description="`+tt.err+`"`, w.Header().Get("WWW-Authenticate"))
+			assert.JSONEq(t, `{"error":"invalid token","details":"`+tt.err+`"}`, w.Body.String())
+		})
+	}
+
+	h = JWTAuth(keys, JWTRequireExp(false))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	req := httptest.NewRequest("GET", "/", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+signTestJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "bob"}, secret))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "exp not required")
+}
+
+func TestJWTAuth_Malformed(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	h := JWTAuth(JWTKeys{{Alg: "HS256", Key: secret}})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Fatal("should not be called")
+	}))
+	valid := signTestJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"exp": time.Now().Add(time.Hour).Unix()}, secret)
+	parts := strings.Split(valid, ".")
+
+	tbl := []struct {
+		name, auth, wwwAuth string
+	}{
+		{"no header", "", `Bearer`},
+		{"basic", "Basic Ym9iOnBhc3N3ZA==", `Bearer`},
+		{"empty bearer", "Bearer ", `Bearer`},
+		{"two parts", "Bearer " + parts[0] + "." + parts[1], `Bearer error="invalid_token", error_description="malformed token"`},
+		{"bad header", "Bearer x." + parts[1] + "." + parts[2], `Bearer error="invalid_token", error_description="malformed header"`},
+		{"bad signature", "Bearer " + parts[0] + "." + parts[1] + ".!", `Bearer error="invalid_token", error_description="malformed signature"`},
+		{"tampered claims", "Bearer " + parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":9999999999}`)) +
+			"." + parts[2], `Bearer error="invalid_token", error_description="invalid signature"`},
+		{"crit", "Bearer " + signTestJWT(t, map[string]any{"alg": "HS256", "crit": []string{"x"}}, map[string]any{}, secret),
+			`Bearer error="invalid_token", error_description="unsupported crit header"`},
+		{"unsupported alg", "Bearer " + signTestJWT(t, map[string]any{"alg": "HS512"}, map[string]any{}, secret),
+			`Bearer error="invalid_token", error_description="unsupported alg"`},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", http.NoBody)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, tt.wwwAuth, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestJWTAuth_Principal(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	keys := JWTKeys{{Alg: "HS256", Key: secret}}
+	var got Principal
+	h := JWTAuth(keys)(RequireScope("orders:read")(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got, _ = GetPrincipal(r.Context())
+	})))
+	call := func(claims map[string]any) int {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+signTestJWT(t, map[string]any{"alg": "HS256"}, claims, secret))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call(map[string]any{"sub": "bob", "scope": "orders:read orders:write", "roles": []string{"admin"}}))
+	assert.Equal(t, Principal{Username: "bob", Method: AuthJWT, Roles: []string{"admin"},
+		Scopes: []string{"orders:read", "orders:write"}}, got)
+	assert.Equal(t, http.StatusOK, call(map[string]any{"sub": "svc", "scp": []string{"orders:read"}}))
+	assert.Equal(t, Principal{Username: "svc", Method: AuthJWT, Scopes: []string{"orders:read"}}, got)
+	assert.Equal(t, http.StatusForbidden, call(map[string]any{"sub": "amy", "scope": "orders:write"}))
+}
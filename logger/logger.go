@@ -4,6 +4,7 @@ package logger
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-pkgz/rest/realip"
@@ -24,6 +26,7 @@ type Middleware struct {
 	logBody        bool
 	maxBodySize    int
 	ipFn           func(ip string) string
+	ipExtractor    *realip.Extractor
 	userFn         func(r *http.Request) (string, error)
 	subjFn         func(r *http.Request) (string, error)
 	bodyFn         func(body string, truncated bool) string
@@ -93,6 +96,8 @@ func (l *Middleware) Handler(next http.Handler) http.Handler {
 				user = u
 			}
 		}
+		slot := &userSlot{}
+		r = r.WithContext(context.WithValue(r.Context(), userSlotKey{}, slot))
 
 		body := l.getBody(r)
 		t1 := time.Now()
@@ -109,7 +114,7 @@ func (l *Middleware) Handler(next http.Handler) http.Handler {
 			// the body is collapsed so an embedded break can't forge additional log records
 			rawurl = lineBreaks.Replace(rawurl)
 
-			remoteIP, err := realip.Get(r)
+			remoteIP, err := l.ipExtractor.Get(r)
 			if err != nil {
 				remoteIP = "unknown ip"
 			}
@@ -117,6 +122,12 @@ func (l *Middleware) Handler(next http.Handler) http.Handler {
 				remoteIP = l.ipFn(remoteIP)
 			}
 
+			if user == "" { // nothing from userFn, take the one reported by auth middleware with SetUser
+				if u := slot.user.Load(); u != nil {
+					user = lineBreaks.Replace(*u)
+				}
+			}
+
 			server := r.URL.Hostname()
 			if server == "" {
 				server = strings.Split(r.Host, ":")[0]
@@ -143,6 +154,24 @@ func (l *Middleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// userSlotKey is the context key of the userSlot of a request
+type userSlotKey struct{}
+
+// userSlot is where SetUser leaves the user for the logger. The logger wraps the handlers which authenticate,
+// and the context they make never gets back to it, so it passes them a slot to fill.
+type userSlot struct {
+	user atomic.Pointer[string]
+}
+
+// SetUser reports the authenticated user of the request to the logger middleware handling it, to be logged
+// when UserFn is not set or returns nothing. Auth middlewares call it, like the BasicAuth family of rest;
+// outside of a logger it does nothing.
+func SetUser(ctx context.Context, user string) {
+	if slot, ok := ctx.Value(userSlotKey{}).(*userSlot); ok {
+		slot.user.Store(&user)
+	}
+}
+
 func (l *Middleware) formatDefault(r *http.Request, p *logParts) string {
 	var bld strings.Builder
 	if l.prefix != "" {
@@ -289,7 +318,7 @@ func peek(r io.Reader, n int64) (reader io.Reader, s string, hasMore bool, err e
 	return io.MultiReader(buf, r), s, true, nil
 }
 
-var keysToHide = []string{"password", "passwd", "secret", "credentials", "token"}
+var keysToHide = []string{"password", "passwd", "secret", "credentials", "token", "api_key", "apikey"}
 
 // Hide query values for keysToHide. May change order of query params.
 // May escape unescaped query params.
@@ -15,6 +15,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/go-pkgz/rest/realip"
 )
 
 func TestLoggerMinimal(t *testing.T) {
@@ -150,6 +152,25 @@ func TestLoggerIP(t *testing.T) {
 	assert.True(t, strings.Contains(s, "- 1.2.3.4!masked -"))
 }
 
+func TestLoggerIPExtractor(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	extractor, err := realip.NewExtractor([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	lb := &mockLgr{}
+	l := New(Log(lb), IPExtractor(extractor))
+
+	req := httptest.NewRequest("GET", "/blah", http.NoBody)
+	req.RemoteAddr = "8.8.8.8:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	l.Handler(handler).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, lb.buf.String(), "- 8.8.8.8 -", "header from untrusted peer ignored")
+
+	lb.buf.Reset()
+	req.RemoteAddr = "10.0.0.1:1234"
+	l.Handler(handler).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, lb.buf.String(), "- 1.2.3.4 -")
+}
+
 func TestLoggerIPAnon(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		_, err := w.Write([]byte("blah blah"))
@@ -518,6 +539,7 @@ func TestSanitizeReqURL(t *testing.T) {
 		{"xyz=123&secret=asdfghjk&key=val", "key=val&secret=********&xyz=123"},
 		{"xyz=123&secret=asdfghjk&key=val&password=1234", "key=val&password=********&secret=********&xyz=123"},
 		{"xyz=тест&passwoRD=1234", "passwoRD=********&xyz=тест"},
+		{"xyz=123&api_key=svc1.abcd", "api_key=********&xyz=123"},
 		{"xyz=тест&password=1234&bar=buzz", "bar=buzz&password=********&xyz=тест"},
 		{"xyz=тест&password=пароль&bar=buzz", "bar=buzz&password=********&xyz=тест"},
 		{"xyz=тест&password=пароль&bar=buzz&q=?sss?ccc", "bar=buzz&password=********&q=?sss?ccc&xyz=тест"},
@@ -672,3 +694,31 @@ func TestLogger_HijackNotSupported(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "does not implement the Hijacker interface")
 }
+
+func TestLoggerSetUser(t *testing.T) {
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetUser(r.Context(), "bob\nforged")
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+
+	lb := &mockLgr{}
+	l := New(Log(lb), Prefix("[INFO] REST"))
+	req := httptest.NewRequest("GET", "/blah", http.NoBody)
+	l.Handler(auth(handler)).ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, strings.HasSuffix(lb.buf.String(), " - bob forged"), lb.buf.String())
+
+	lb = &mockLgr{}
+	l = New(Log(lb), ApacheCombined)
+	l.Handler(auth(handler)).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, lb.buf.String(), " - bob forged [")
+
+	lb = &mockLgr{}
+	l = New(Log(lb), UserFn(func(*http.Request) (string, error) { return "from-fn", nil }))
+	l.Handler(auth(handler)).ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, strings.HasSuffix(lb.buf.String(), " - from-fn"), "UserFn wins")
+
+	SetUser(req.Context(), "nobody") // no logger, nothing to do
+}
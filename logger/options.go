@@ -2,6 +2,8 @@ package logger
 
 import (
 	"net/http"
+
+	"github.com/go-pkgz/rest/realip"
 )
 
 // Option func type
@@ -35,6 +37,14 @@ func IPfn(ipFn func(ip string) string) Option {
 	}
 }
 
+// IPExtractor sets the extractor getting the client's IP, which trusts forwarding headers from configured
+// proxies only. If extractor is nil then realip.Get is used.
+func IPExtractor(extractor *realip.Extractor) Option {
+	return func(l *Middleware) {
+		l.ipExtractor = extractor
+	}
+}
+
 // UserFn triggers user name logging if userFn is not nil.
 func UserFn(userFn func(r *http.Request) (string, error)) Option {
 	return func(l *Middleware) {
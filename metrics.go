@@ -22,11 +22,12 @@ func MetricsAllowAll() func(http.Handler) http.Handler {
 }
 
 func metricsHandler(allowAll bool, onlyIps []string) func(http.Handler) http.Handler {
+	allowed := NewIPSet(onlyIps...)
 	return func(h http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			if r.Method == "GET" && strings.HasSuffix(strings.ToLower(r.URL.Path), "/metrics") {
 				if !allowAll {
-					if matched, ip, err := matchSourceIP(r, onlyIps); !matched || err != nil {
+					if matched, ip, err := matchSourceIP(nil, r, allowed); !matched || err != nil {
 						_ = EncodeJSON(w, http.StatusForbidden, JSON{"error": fmt.Sprintf("ip %s rejected", ip)})
 						return
 					}
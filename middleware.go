@@ -176,6 +176,21 @@ func RealIP(h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// RealIPWith is a middleware that sets a http.Request's RemoteAddr to the client's real IP, as the extractor
+// gets it. Unlike RealIP, it is safe without a proxy sanitizing headers, as the extractor honors forwarding
+// headers only from the trusted proxies it is configured with.
+func RealIPWith(extractor *realip.Extractor) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if rip, err := extractor.Get(r); err == nil {
+				r.RemoteAddr = rip
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
 // Reject is a middleware that conditionally rejects requests with a given status code and message.
 // user-defined condition function rejectFn is used to determine if the request should be rejected.
 func Reject(errCode int, errMsg string, rejectFn func(r *http.Request) bool) func(h http.Handler) http.Handler {
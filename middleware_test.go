@@ -268,6 +268,24 @@ func TestRealIP(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestRealIPWith(t *testing.T) {
+	var got string
+	handler := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { got = r.RemoteAddr })
+	extractor, err := realip.NewExtractor([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	h := RealIPWith(extractor)(handler)
+
+	req := httptest.NewRequest("GET", "/something", http.NoBody)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "1.2.3.4", got)
+
+	req.RemoteAddr = "5.5.5.5:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "5.5.5.5", got, "untrusted peer keeps its address")
+}
+
 func TestHealthPassed(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		_, err := w.Write([]byte("blah blah"))
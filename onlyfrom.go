@@ -2,9 +2,7 @@ package rest
 
 import (
 	"fmt"
-	"net"
 	"net/http"
-	"strings"
 
 	"github.com/go-pkgz/rest/realip"
 )
@@ -13,20 +11,74 @@ import (
 // Rules can be complete IPs (like 192.168.1.12), textual prefixes (129.168.), or CIDRs (192.168.0.0/16).
 // Complete IPs use semantic address equality, CIDRs use network containment, and all other rules use prefix matching.
 func OnlyFrom(onlyIps ...string) func(http.Handler) http.Handler {
+	return OnlyFromWith(nil, onlyIps...)
+}
+
+// OnlyFromWith is OnlyFrom getting the source IP with the extractor, which trusts forwarding headers
+// from configured proxies only. A nil extractor works as realip.Get.
+func OnlyFromWith(extractor *realip.Extractor, onlyIps ...string) func(http.Handler) http.Handler {
+	return IPFilter(IPPolicy{Allow: NewIPSet(onlyIps...), Extractor: extractor})
+}
+
+// DenyFrom middleware rejects requests from the listed source IPs with 403 and lets all others through.
+// Rules are the same as for OnlyFrom.
+func DenyFrom(denyIps ...string) func(http.Handler) http.Handler {
+	return DenyFromWith(nil, denyIps...)
+}
+
+// DenyFromWith is DenyFrom getting the source IP with the extractor. A nil extractor works as realip.Get.
+func DenyFromWith(extractor *realip.Extractor, denyIps ...string) func(http.Handler) http.Handler {
+	return IPFilter(IPPolicy{Deny: NewIPSet(denyIps...), Extractor: extractor})
+}
+
+// IPPrecedence decides which list wins for an address in both lists of IPPolicy
+type IPPrecedence int
+
+// enum of IPPrecedence values
+const (
+	DenyOverAllow IPPrecedence = iota // an address in both lists is rejected, e.g. an abuser inside an allowed network
+	AllowOverDeny                     // an address in both lists is allowed, e.g. an office inside a blocked network
+)
+
+// IPPolicy combines allowed and denied source IPs. An address in only one of the lists gets what that list says,
+// one in both is decided by Precedence. Any other address is allowed if Allow is nil or an empty IPSet and rejected
+// otherwise; a file-backed Allow, like IPList, rejects everyone while its file has no rules.
+type IPPolicy struct {
+	Allow      IPMatcher
+	Deny       IPMatcher
+	Precedence IPPrecedence
+	Extractor  *realip.Extractor // gets the source ip, nil works as realip.Get
+}
+
+// Allowed reports whether the ip passes the policy
+func (p IPPolicy) Allowed(ip string) bool {
+	allowed, denied := p.Allow != nil && p.Allow.Contains(ip), p.Deny != nil && p.Deny.Contains(ip)
+	switch {
+	case allowed && denied:
+		return p.Precedence == AllowOverDeny
+	case denied:
+		return false
+	case allowed:
+		return true
+	}
+	return emptyIPMatcher(p.Allow)
+}
+
+// IPFilter middleware lets through requests the policy allows and rejects others with 403
+func IPFilter(policy IPPolicy) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if len(onlyIps) == 0 {
+			if emptyIPMatcher(policy.Allow) && emptyIPMatcher(policy.Deny) {
 				// no restrictions if no ips defined
 				h.ServeHTTP(w, r)
 				return
 			}
-			matched, ip, err := matchSourceIP(r, onlyIps)
+			ip, err := policy.Extractor.Get(r)
 			if err != nil {
 				_ = EncodeJSON(w, http.StatusInternalServerError, JSON{"error": fmt.Sprintf("can't get realip: %s", err)})
 				return
 			}
-			if matched {
-				// matched ip - allow
+			if policy.Allowed(ip) {
 				h.ServeHTTP(w, r)
 				return
 			}
@@ -37,30 +89,21 @@ func OnlyFrom(onlyIps ...string) func(http.Handler) http.Handler {
 	}
 }
 
-// matchSourceIP returns true if request's ip matches any of ips
-func matchSourceIP(r *http.Request, ips []string) (result bool, match string, err error) {
-	ip, err := realip.Get(r)
+// emptyIPMatcher reports whether the matcher is unset or a static set without rules. Other matchers, like IPList,
+// count as set even when empty, so an allow list emptied by a reload rejects everyone rather than allowing everyone.
+func emptyIPMatcher(m IPMatcher) bool {
+	if m == nil {
+		return true
+	}
+	s, static := m.(*IPSet)
+	return static && s.Len() == 0
+}
+
+// matchSourceIP returns true if request's ip, as the extractor gets it, is in the set
+func matchSourceIP(extractor *realip.Extractor, r *http.Request, ips *IPSet) (result bool, match string, err error) {
+	ip, err := extractor.Get(r)
 	if err != nil {
 		return false, "", fmt.Errorf("can't get realip: %w", err) // we can't get ip, so no match
 	}
-	parsedIP := net.ParseIP(ip)
-	// check for cidr, complete ip, or ip prefix
-	for _, exclIP := range ips {
-		if _, cidrnet, err := net.ParseCIDR(exclIP); err == nil {
-			if cidrnet.Contains(parsedIP) {
-				return true, ip, nil
-			}
-			continue
-		}
-		if allowedIP := net.ParseIP(exclIP); allowedIP != nil {
-			if allowedIP.Equal(parsedIP) {
-				return true, ip, nil
-			}
-			continue
-		}
-		if strings.HasPrefix(ip, exclIP) {
-			return true, ip, nil
-		}
-	}
-	return false, ip, nil
+	return ips.Contains(ip), ip, nil
 }
@@ -8,6 +8,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/go-pkgz/rest/realip"
 )
 
 func TestOnlyFromAllowedIP(t *testing.T) {
@@ -127,7 +129,7 @@ func TestMatchSourceIPRules(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
 			req.Header.Set("X-Real-IP", tt.source)
 
-			matched, source, err := matchSourceIP(req, tt.rules)
+			matched, source, err := matchSourceIP(nil, req, NewIPSet(tt.rules...))
 			require.NoError(t, err)
 			assert.Equal(t, tt.matched, matched)
 			assert.Equal(t, tt.source, source)
@@ -239,3 +241,82 @@ func TestOnlyFrom_EmptyList(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "allowed", string(b))
 }
+
+func TestOnlyFromWith(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	extractor, err := realip.NewExtractor([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	h := OnlyFromWith(extractor, "1.1.1.0/24")(handler)
+
+	tbl := []struct {
+		remoteAddr, xff string
+		code            int
+	}{
+		{"10.0.0.1:1234", "1.1.1.1", http.StatusOK},
+		{"10.0.0.1:1234", "8.8.8.8", http.StatusForbidden},
+		{"8.8.8.8:1234", "1.1.1.1", http.StatusForbidden}, // spoofed header from an untrusted peer
+		{"1.1.1.5:1234", "", http.StatusOK},
+	}
+	for _, tt := range tbl {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, tt)
+	}
+}
+
+func TestDenyFrom(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	h := DenyFrom("1.1.1.0/24", "2.2.2.2")(handler)
+
+	tbl := []struct {
+		ip   string
+		code int
+	}{
+		{"1.1.1.1", http.StatusForbidden},
+		{"2.2.2.2", http.StatusForbidden},
+		{"3.3.3.3", http.StatusOK},
+	}
+	for _, tt := range tbl {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("X-Real-IP", tt.ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, tt.ip)
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	allow, deny := NewIPSet("20.0.0.0/8", "1.1.1.1"), NewIPSet("20.1.0.0/16", "1.1.1.")
+
+	tbl := []struct {
+		ip                string
+		denyOver, allowOv int
+	}{
+		{"20.2.3.4", http.StatusOK, http.StatusOK},              // allowed only
+		{"20.1.2.3", http.StatusForbidden, http.StatusOK},       // in both
+		{"1.1.1.1", http.StatusForbidden, http.StatusOK},        // in both, textual prefix
+		{"1.1.1.2", http.StatusForbidden, http.StatusForbidden}, // denied only
+		{"8.8.8.8", http.StatusForbidden, http.StatusForbidden}, // in neither, allow list not empty
+	}
+	denyFirst := IPFilter(IPPolicy{Allow: allow, Deny: deny})(handler)
+	allowFirst := IPFilter(IPPolicy{Allow: allow, Deny: deny, Precedence: AllowOverDeny})(handler)
+	for _, tt := range tbl {
+		req := httptest.NewRequest("GET", "/", http.NoBody)
+		req.Header.Set("X-Real-IP", tt.ip)
+		w := httptest.NewRecorder()
+		denyFirst.ServeHTTP(w, req)
+		assert.Equal(t, tt.denyOver, w.Code, tt.ip)
+		w = httptest.NewRecorder()
+		allowFirst.ServeHTTP(w, req)
+		assert.Equal(t, tt.allowOv, w.Code, tt.ip)
+	}
+
+	assert.True(t, IPPolicy{Deny: deny}.Allowed("8.8.8.8"), "empty allow list lets others through")
+	assert.True(t, IPPolicy{}.Allowed("8.8.8.8"))
+}
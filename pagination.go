@@ -0,0 +1,258 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for a cursor that is malformed or fails the signature check
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PaginatorConfig defines pagination defaults and limits.
+// Use PageOpt functions to customize.
+type PaginatorConfig struct {
+	// DefaultLimit is used when the request has no limit param. Default: 20
+	DefaultLimit int
+	// MaxLimit caps the limit a client can ask for, larger values are lowered to it. Default: 100
+	MaxLimit int
+	// CursorSecret is the key cursors are signed with. Default: random key made by NewPaginator,
+	// so cursors don't survive a restart and aren't accepted by other instances
+	CursorSecret []byte
+	// TotalHeader is the name of the header reporting the collection size, empty disables it.
+	// Default: X-Total-Count
+	TotalHeader string
+}
+
+// PageOpt is a functional option for PaginatorConfig
+type PageOpt func(*PaginatorConfig)
+
+// PageDefaultLimit sets the limit used when the request doesn't ask for one
+func PageDefaultLimit(limit int) PageOpt {
+	return func(c *PaginatorConfig) {
+		c.DefaultLimit = limit
+	}
+}
+
+// PageMaxLimit sets the largest limit a client can ask for
+func PageMaxLimit(limit int) PageOpt {
+	return func(c *PaginatorConfig) {
+		c.MaxLimit = limit
+	}
+}
+
+// PageCursorSecret sets the key used to sign cursors.
+// Set it to the same value on every instance if cursors have to work across them or across restarts.
+func PageCursorSecret(secret []byte) PageOpt {
+	return func(c *PaginatorConfig) {
+		c.CursorSecret = secret
+	}
+}
+
+// PageTotalHeader sets the name of the total-count header. Empty string disables it.
+func PageTotalHeader(name string) PageOpt {
+	return func(c *PaginatorConfig) {
+		c.TotalHeader = name
+	}
+}
+
+// Paginator parses pagination params from requests and emits RFC 8288 Link headers for the responses.
+// Offset pagination is driven by limit and offset (or page) query params, cursor pagination by limit
+// and cursor. Cursors are opaque to clients, they carry caller-defined JSON signed with HMAC-SHA256,
+// so a client can't forge or alter one.
+type Paginator struct {
+	cfg PaginatorConfig
+}
+
+// Page is the pagination request parsed by Paginator.Parse
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor string // raw cursor param, already verified; decode it with Paginator.DecodeCursor
+
+	byPage bool // the request used page rather than offset, links follow the same style
+}
+
+// NewPaginator makes a Paginator with the given options
+func NewPaginator(opts ...PageOpt) *Paginator {
+	cfg := PaginatorConfig{DefaultLimit: 20, MaxLimit: 100, TotalHeader: "X-Total-Count"}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 100
+	}
+	if cfg.DefaultLimit <= 0 || cfg.DefaultLimit > cfg.MaxLimit {
+		cfg.DefaultLimit = min(20, cfg.MaxLimit)
+	}
+	if len(cfg.CursorSecret) == 0 {
+		cfg.CursorSecret = make([]byte, 32)
+		_, _ = rand.Read(cfg.CursorSecret) // never fails, see crypto/rand docs
+	}
+	return &Paginator{cfg: cfg}
+}
+
+// Parse extracts limit, offset, page and cursor query params from the request.
+// A missing limit gets the default and a too large one is capped, while malformed or negative values
+// are reported as errors. page is 1-based and turned into an offset. A cursor can't be combined with
+// offset or page, and is rejected with ErrInvalidCursor if its signature doesn't match.
+func (p *Paginator) Parse(r *http.Request) (Page, error) {
+	q := r.URL.Query()
+	res := Page{Limit: p.cfg.DefaultLimit}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return Page{}, fmt.Errorf("incorrect limit %q", v)
+		}
+		res.Limit = min(limit, p.cfg.MaxLimit)
+	}
+
+	offsetParam, pageParam, cursorParam := q.Get("offset"), q.Get("page"), q.Get("cursor")
+	if cursorParam != "" && (offsetParam != "" || pageParam != "") {
+		return Page{}, errors.New("cursor can't be combined with offset or page")
+	}
+	if offsetParam != "" && pageParam != "" {
+		return Page{}, errors.New("offset can't be combined with page")
+	}
+
+	switch {
+	case offsetParam != "":
+		offset, err := strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return Page{}, fmt.Errorf("incorrect offset %q", offsetParam)
+		}
+		res.Offset = offset
+	case pageParam != "":
+		page, err := strconv.Atoi(pageParam)
+		if err != nil || page < 1 || page-1 > math.MaxInt/res.Limit {
+			return Page{}, fmt.Errorf("incorrect page %q", pageParam)
+		}
+		res.Offset = (page - 1) * res.Limit
+		res.byPage = true
+	case cursorParam != "":
+		if _, err := p.verifyCursor(cursorParam); err != nil {
+			return Page{}, err
+		}
+		res.Cursor = cursorParam
+	}
+	return res, nil
+}
+
+// EncodeCursor makes a signed opaque cursor carrying v encoded as json
+func (p *Paginator) EncodeCursor(v any) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(p.sign(payload)), nil
+}
+
+// DecodeCursor verifies the cursor and decodes the value it carries into v
+func (p *Paginator) DecodeCursor(cursor string, v any) error {
+	payload, err := p.verifyCursor(cursor)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return nil
+}
+
+// SetLinks sets the Link header with first, prev, next and last relations for an offset page, and the
+// total-count header. total is the size of the collection; when it is negative the size is treated as
+// unknown, so last and the total-count header are left out and next is always present.
+func (p *Paginator) SetLinks(w http.ResponseWriter, r *http.Request, page Page, total int) {
+	if page.Limit <= 0 {
+		page.Limit = p.cfg.DefaultLimit
+	}
+	link := func(offset int) string {
+		if page.byPage {
+			return pageURL(r, map[string]string{"limit": strconv.Itoa(page.Limit), "page": strconv.Itoa(offset/page.Limit + 1)})
+		}
+		return pageURL(r, map[string]string{"limit": strconv.Itoa(page.Limit), "offset": strconv.Itoa(offset)})
+	}
+
+	links := []string{linkValue(link(0), "first")}
+	if page.Offset > 0 {
+		links = append(links, linkValue(link(max(page.Offset-page.Limit, 0)), "prev"))
+	}
+	if total < 0 || page.Offset+page.Limit < total {
+		links = append(links, linkValue(link(page.Offset+page.Limit), "next"))
+	}
+	if total >= 0 {
+		links = append(links, linkValue(link(max(total-1, 0)/page.Limit*page.Limit), "last"))
+		if p.cfg.TotalHeader != "" {
+			w.Header().Set(p.cfg.TotalHeader, strconv.Itoa(total))
+		}
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// SetCursorLinks sets the Link header for a cursor page. next and prev are cursors made by EncodeCursor,
+// empty ones are left out. first points to the collection without a cursor; last is never set, as
+// cursors can't reach it directly.
+func (p *Paginator) SetCursorLinks(w http.ResponseWriter, r *http.Request, page Page, next, prev string) {
+	limit := strconv.Itoa(page.Limit)
+	links := []string{linkValue(pageURL(r, map[string]string{"limit": limit}), "first")}
+	if prev != "" {
+		links = append(links, linkValue(pageURL(r, map[string]string{"limit": limit, "cursor": prev}), "prev"))
+	}
+	if next != "" {
+		links = append(links, linkValue(pageURL(r, map[string]string{"limit": limit, "cursor": next}), "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+func (p *Paginator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.cfg.CursorSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// verifyCursor checks the cursor signature and returns the payload it carries
+func (p *Paginator) verifyCursor(cursor string) ([]byte, error) {
+	encPayload, encSig, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, p.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+	return payload, nil
+}
+
+// pageURL makes a reference to the requested resource with pagination params replaced by the given ones.
+// All the other query params are kept, so filters and sorting carry over to the linked pages.
+func pageURL(r *http.Request, params map[string]string) string {
+	q := r.URL.Query()
+	for _, k := range []string{"limit", "offset", "page", "cursor"} {
+		q.Del(k)
+	}
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u := *r.URL // shallow copy
+	u.Scheme, u.Host, u.User = "", "", nil
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func linkValue(target, rel string) string {
+	return fmt.Sprintf("<%s>; rel=%q", target, rel)
+}
@@ -0,0 +1,193 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginator_Parse(t *testing.T) {
+	p := NewPaginator(PageDefaultLimit(10), PageMaxLimit(50))
+
+	tbl := []struct {
+		query  string
+		limit  int
+		offset int
+		err    bool
+	}{
+		{"", 10, 0, false},
+		{"limit=5", 5, 0, false},
+		{"limit=500", 50, 0, false},
+		{"limit=5&offset=15", 5, 15, false},
+		{"limit=5&page=3", 5, 10, false},
+		{"page=1", 10, 0, false},
+		{"limit=0", 0, 0, true},
+		{"limit=abc", 0, 0, true},
+		{"offset=-1", 0, 0, true},
+		{"page=0", 0, 0, true},
+		{"page=9223372036854775807", 0, 0, true},
+		{"offset=1&page=2", 0, 0, true},
+		{"offset=1&cursor=abc", 0, 0, true},
+		{"cursor=abc", 0, 0, true},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items?"+tt.query, http.NoBody)
+			page, err := p.Parse(req)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.limit, page.Limit)
+			assert.Equal(t, tt.offset, page.Offset)
+		})
+	}
+}
+
+func TestPaginator_Cursor(t *testing.T) {
+	type pos struct {
+		ID   int    `json:"id"`
+		Sort string `json:"sort"`
+	}
+	p := NewPaginator(PageCursorSecret([]byte("secret")))
+
+	cursor, err := p.EncodeCursor(pos{ID: 42, Sort: "name"})
+	require.NoError(t, err)
+	assert.NotContains(t, cursor, "42", "cursor is encoded")
+
+	req := httptest.NewRequest("GET", "/items?limit=5&cursor="+cursor, http.NoBody)
+	page, err := p.Parse(req)
+	require.NoError(t, err)
+	assert.Equal(t, cursor, page.Cursor)
+	assert.Equal(t, 5, page.Limit)
+
+	var res pos
+	require.NoError(t, p.DecodeCursor(page.Cursor, &res))
+	assert.Equal(t, pos{ID: 42, Sort: "name"}, res)
+
+	t.Run("tampered payload", func(t *testing.T) {
+		forged, err := p.EncodeCursor(pos{ID: 1, Sort: "name"})
+		require.NoError(t, err)
+		payload, _, _ := strings.Cut(forged, ".")
+		_, sig, _ := strings.Cut(cursor, ".")
+		err = p.DecodeCursor(payload+"."+sig, &res)
+		require.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("other secret", func(t *testing.T) {
+		other := NewPaginator(PageCursorSecret([]byte("other")))
+		req := httptest.NewRequest("GET", "/items?cursor="+cursor, http.NoBody)
+		_, err := other.Parse(req)
+		require.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("random secret by default", func(t *testing.T) {
+		p1, p2 := NewPaginator(), NewPaginator()
+		c, err := p1.EncodeCursor(1)
+		require.NoError(t, err)
+		var v int
+		require.NoError(t, p1.DecodeCursor(c, &v))
+		require.ErrorIs(t, p2.DecodeCursor(c, &v), ErrInvalidCursor)
+	})
+}
+
+func TestPaginator_SetLinks(t *testing.T) {
+	p := NewPaginator()
+
+	tbl := []struct {
+		name  string
+		query string
+		total int
+		links []string
+		count string
+	}{
+		{
+			name: "first page", query: "limit=10&sort=name", total: 35, count: "35",
+			links: []string{
+				`</items?limit=10&offset=0&sort=name>; rel="first"`,
+				`</items?limit=10&offset=10&sort=name>; rel="next"`,
+				`</items?limit=10&offset=30&sort=name>; rel="last"`,
+			},
+		},
+		{
+			name: "middle page", query: "limit=10&offset=15", total: 35, count: "35",
+			links: []string{
+				`</items?limit=10&offset=0>; rel="first"`,
+				`</items?limit=10&offset=5>; rel="prev"`,
+				`</items?limit=10&offset=25>; rel="next"`,
+				`</items?limit=10&offset=30>; rel="last"`,
+			},
+		},
+		{
+			name: "last page", query: "limit=10&page=4", total: 35, count: "35",
+			links: []string{
+				`</items?limit=10&page=1>; rel="first"`,
+				`</items?limit=10&page=3>; rel="prev"`,
+				`</items?limit=10&page=4>; rel="last"`,
+			},
+		},
+		{
+			name: "empty collection", query: "", total: 0, count: "0",
+			links: []string{
+				`</items?limit=20&offset=0>; rel="first"`,
+				`</items?limit=20&offset=0>; rel="last"`,
+			},
+		},
+		{
+			name: "unknown total", query: "limit=10&offset=10", total: -1, count: "",
+			links: []string{
+				`</items?limit=10&offset=0>; rel="first"`,
+				`</items?limit=10&offset=0>; rel="prev"`,
+				`</items?limit=10&offset=20>; rel="next"`,
+			},
+		},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/items?"+tt.query, http.NoBody)
+			page, err := p.Parse(req)
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			p.SetLinks(w, req, page, tt.total)
+			assert.Equal(t, strings.Join(tt.links, ", "), w.Header().Get("Link"))
+			assert.Equal(t, tt.count, w.Header().Get("X-Total-Count"))
+		})
+	}
+}
+
+func TestPaginator_SetLinksCustomTotalHeader(t *testing.T) {
+	req := httptest.NewRequest("GET", "/items", http.NoBody)
+
+	w := httptest.NewRecorder()
+	NewPaginator(PageTotalHeader("X-Count")).SetLinks(w, req, Page{Limit: 10}, 5)
+	assert.Equal(t, "5", w.Header().Get("X-Count"))
+	assert.Empty(t, w.Header().Get("X-Total-Count"))
+
+	w = httptest.NewRecorder()
+	NewPaginator(PageTotalHeader("")).SetLinks(w, req, Page{Limit: 10}, 5)
+	assert.Empty(t, w.Header().Get("X-Total-Count"))
+	assert.NotEmpty(t, w.Header().Get("Link"))
+}
+
+func TestPaginator_SetCursorLinks(t *testing.T) {
+	p := NewPaginator()
+	next, err := p.EncodeCursor(20)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/items?limit=10&q=abc", http.NoBody)
+	page, err := p.Parse(req)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	p.SetCursorLinks(w, req, page, next, "")
+	assert.Equal(t, `</items?limit=10&q=abc>; rel="first", </items?cursor=`+next+`&limit=10&q=abc>; rel="next"`,
+		w.Header().Get("Link"))
+	assert.Empty(t, w.Header().Get("X-Total-Count"))
+}
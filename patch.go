@@ -0,0 +1,478 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrPatchTestFailed is wrapped by PatchError when a json patch "test" operation doesn't match
+var ErrPatchTestFailed = errors.New("test operation failed")
+
+var errPatchPathNotFound = errors.New("path not found")
+
+// PatchError is returned by the patch helpers. Status is the http status the failure maps to:
+//   - 400 for a malformed patch document
+//   - 409 for a patch that can't be applied to the current state, like a missing path or a failed test
+//   - 412 for a failed test, when enabled with PatchTestAsPrecondition
+//   - 415 for a request with a content type that isn't a supported patch format
+//   - 422 for a patched document that no longer fits the target type
+type PatchError struct {
+	Status int
+	Op     string // json patch operation, empty for merge patch and document level failures
+	Path   string // json pointer of the failed operation
+	Err    error
+}
+
+// Error implements error interface
+func (e *PatchError) Error() string {
+	if e.Op != "" {
+		return fmt.Sprintf("patch %s %q: %v", e.Op, e.Path, e.Err)
+	}
+	return fmt.Sprintf("patch: %v", e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PatchError) Unwrap() error { return e.Err }
+
+// PatchConfig defines how patch failures are reported.
+// Use PatchOpt functions to customize.
+type PatchConfig struct {
+	// TestFailedStatus is the status of a failed "test" operation. Default: 409
+	TestFailedStatus int
+}
+
+// PatchOpt is a functional option for PatchConfig
+type PatchOpt func(*PatchConfig)
+
+// PatchTestAsPrecondition makes a failed "test" operation a StatusPreconditionFailed (412) rather than a
+// StatusConflict (409), for APIs treating tests as preconditions the way If-Match is
+func PatchTestAsPrecondition(enable bool) PatchOpt {
+	return func(c *PatchConfig) {
+		c.TestFailedStatus = http.StatusConflict
+		if enable {
+			c.TestFailedStatus = http.StatusPreconditionFailed
+		}
+	}
+}
+
+// ApplyPatch applies the request body to target, dispatching on Content-Type: application/merge-patch+json
+// is handled as RFC 7396 merge patch, application/json-patch+json as RFC 6902 JSON Patch. Other content types
+// get a PatchError with StatusUnsupportedMediaType (415). The body is read whole, so cap it with SizeLimit.
+func ApplyPatch[T any](r *http.Request, target *T, opts ...PatchOpt) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json-patch+json" {
+		return &PatchError{Status: http.StatusUnsupportedMediaType,
+			Err: fmt.Errorf("unsupported patch content type %q", r.Header.Get("Content-Type"))}
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &PatchError{Status: http.StatusBadRequest, Err: fmt.Errorf("read patch: %w", err)}
+	}
+	if mediaType == "application/merge-patch+json" {
+		return MergePatch(target, patch)
+	}
+	return JSONPatch(target, patch, opts...)
+}
+
+// MergePatch applies an RFC 7396 merge patch to target. Members set to null are removed, objects are merged
+// recursively and any other value replaces the target's one. The result is decoded into a fresh value, so
+// fields of target that don't take part in json encoding are reset.
+func MergePatch[T any](target *T, patch []byte) error {
+	doc, err := patchDocument(target)
+	if err != nil {
+		return err
+	}
+	p, err := decodePatchJSON(patch)
+	if err != nil {
+		return &PatchError{Status: http.StatusBadRequest, Err: fmt.Errorf("decode merge patch: %w", err)}
+	}
+	return patchResult(target, mergePatchValue(doc, p))
+}
+
+// JSONPatch applies an RFC 6902 JSON Patch document to target. Operations apply in order and atomically,
+// target is left untouched unless all of them succeed. The result is decoded into a fresh value, so fields
+// of target that don't take part in json encoding are reset.
+func JSONPatch[T any](target *T, patch []byte, opts ...PatchOpt) error {
+	cfg := PatchConfig{TestFailedStatus: http.StatusConflict}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var ops []struct {
+		Op    string     `json:"op"`
+		Path  *string    `json:"path"`
+		From  *string    `json:"from"`
+		Value patchValue `json:"value"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return &PatchError{Status: http.StatusBadRequest, Err: fmt.Errorf("decode json patch: %w", err)}
+	}
+
+	doc, err := patchDocument(target)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		badRequest := func(err error) error {
+			return &PatchError{Status: http.StatusBadRequest, Op: op.Op, Path: deref(op.Path), Err: err}
+		}
+		if op.Path == nil {
+			return badRequest(errors.New("missing path"))
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return badRequest(err)
+		}
+
+		var value any
+		switch op.Op {
+		case "add", "replace", "test":
+			if !op.Value.set {
+				return badRequest(errors.New("missing value"))
+			}
+			if value, err = decodePatchJSON(op.Value.raw); err != nil {
+				return badRequest(err)
+			}
+		}
+		var from []string
+		switch op.Op {
+		case "move", "copy":
+			if op.From == nil {
+				return badRequest(errors.New("missing from"))
+			}
+			if from, err = parsePointer(*op.From); err != nil {
+				return badRequest(err)
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = patchAdd(doc, path, value)
+		case "remove":
+			doc, _, err = patchRemove(doc, path)
+		case "replace":
+			if _, err = patchGet(doc, path); err == nil {
+				doc, _, err = patchRemove(doc, path)
+			}
+			if err == nil {
+				doc, err = patchAdd(doc, path, value)
+			}
+		case "move":
+			if isProperPrefix(from, path) {
+				return badRequest(errors.New("can't move a value into itself"))
+			}
+			var moved any
+			if doc, moved, err = patchRemove(doc, from); err == nil {
+				doc, err = patchAdd(doc, path, moved)
+			}
+		case "copy":
+			var copied any
+			if copied, err = patchGet(doc, from); err == nil {
+				doc, err = patchAdd(doc, path, deepCopyJSON(copied))
+			}
+		case "test":
+			var current any
+			if current, err = patchGet(doc, path); err == nil && !jsonEqual(current, value) {
+				return &PatchError{Status: cfg.TestFailedStatus, Op: op.Op, Path: *op.Path, Err: ErrPatchTestFailed}
+			}
+		default:
+			return badRequest(fmt.Errorf("unknown operation %q", op.Op))
+		}
+		if err != nil {
+			return &PatchError{Status: http.StatusConflict, Op: op.Op, Path: *op.Path, Err: err}
+		}
+	}
+	return patchResult(target, doc)
+}
+
+// patchDocument encodes target to its generic json form, the one patches are applied to
+func patchDocument[T any](target *T) (any, error) {
+	data, err := json.Marshal(target)
+	if err != nil {
+		return nil, &PatchError{Status: http.StatusInternalServerError, Err: fmt.Errorf("encode target: %w", err)}
+	}
+	return decodePatchJSON(data)
+}
+
+// patchValue is the "value" member of a json patch operation, telling an explicit null from a missing member
+type patchValue struct {
+	raw json.RawMessage
+	set bool
+}
+
+// UnmarshalJSON is called for any present member, null included
+func (v *patchValue) UnmarshalJSON(data []byte) error {
+	v.raw, v.set = append(json.RawMessage(nil), data...), true
+	return nil
+}
+
+// patchResult decodes the patched document into a fresh value and stores it in target. Unknown fields are
+// rejected, a patch adding members the type doesn't have is as unprocessable as one with a wrong type.
+func patchResult[T any](target *T, doc any) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return &PatchError{Status: http.StatusUnprocessableEntity, Err: fmt.Errorf("encode patched document: %w", err)}
+	}
+	var res T
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return &PatchError{Status: http.StatusUnprocessableEntity, Err: fmt.Errorf("decode patched document: %w", err)}
+	}
+	*target = res
+	return nil
+}
+
+// decodePatchJSON decodes a single json value, keeping numbers as json.Number so large integers survive
+func decodePatchJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var res any
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after json value")
+	}
+	return res, nil
+}
+
+func mergePatchValue(target, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]any)
+	if !ok {
+		tm = map[string]any{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+			continue
+		}
+		tm[k] = mergePatchValue(tm[k], v)
+	}
+	return tm
+}
+
+// parsePointer splits an RFC 6901 json pointer into unescaped reference tokens, "" is the whole document
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isProperPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses an array reference token, allowing len itself only when appending is allowed
+func arrayIndex(tok string, length int, appending bool) (int, error) {
+	if appending && tok == "-" {
+		return length, nil
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') || strings.TrimLeft(tok, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i > length || (i == length && !appending) {
+		return 0, fmt.Errorf("array index %q out of range", tok)
+	}
+	return i, nil
+}
+
+func patchGet(doc any, path []string) (any, error) {
+	for _, tok := range path {
+		switch c := doc.(type) {
+		case map[string]any:
+			v, ok := c[tok]
+			if !ok {
+				return nil, errPatchPathNotFound
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, errPatchPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// patchUpdate walks to the parent of the location and replaces the parent with what fn returns,
+// which lets fn grow or shrink arrays
+func patchUpdate(doc any, path []string, fn func(parent any, tok string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch c := doc.(type) {
+	case map[string]any:
+		child, ok := c[path[0]]
+		if !ok {
+			return nil, errPatchPathNotFound
+		}
+		updated, err := patchUpdate(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[path[0]] = updated
+		return c, nil
+	case []any:
+		i, err := arrayIndex(path[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := patchUpdate(c[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = updated
+		return c, nil
+	}
+	return nil, errPatchPathNotFound
+}
+
+func patchAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return patchUpdate(doc, path, func(parent any, tok string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			c[tok] = value
+			return c, nil
+		case []any:
+			i, err := arrayIndex(tok, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], append([]any{value}, c[i:]...)...), nil
+		}
+		return nil, errPatchPathNotFound
+	})
+}
+
+func patchRemove(doc any, path []string) (res, removed any, err error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	res, err = patchUpdate(doc, path, func(parent any, tok string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			v, ok := c[tok]
+			if !ok {
+				return nil, errPatchPathNotFound
+			}
+			removed = v
+			delete(c, tok)
+			return c, nil
+		case []any:
+			i, err := arrayIndex(tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = c[i]
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, errPatchPathNotFound
+	})
+	return res, removed, err
+}
+
+func deepCopyJSON(v any) any {
+	switch c := v.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(c))
+		for k, vv := range c {
+			res[k] = deepCopyJSON(vv)
+		}
+		return res
+	case []any:
+		res := make([]any, len(c))
+		for i, vv := range c {
+			res[i] = deepCopyJSON(vv)
+		}
+		return res
+	}
+	return v
+}
+
+// jsonEqual compares generic json values, numbers by their value rather than their spelling
+func jsonEqual(a, b any) bool {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if other, ok := bv[k]; !ok || !jsonEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if av == bv {
+			return true
+		}
+		af, errA := av.Float64()
+		bf, errB := bv.Float64()
+		return errA == nil && errB == nil && af == bf
+	}
+	return a == b
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
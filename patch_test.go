@@ -0,0 +1,167 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type patchUser struct {
+	Name  string            `json:"name"`
+	Age   int               `json:"age"`
+	Tags  []string          `json:"tags,omitempty"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+func TestMergePatch(t *testing.T) {
+	u := patchUser{Name: "bob", Age: 30, Tags: []string{"a"}, Attrs: map[string]string{"k1": "v1", "k2": "v2"}}
+	require.NoError(t, MergePatch(&u, []byte(`{"age":31,"tags":null,"attrs":{"k1":null,"k3":"v3"}}`)))
+	assert.Equal(t, patchUser{Name: "bob", Age: 31, Attrs: map[string]string{"k2": "v2", "k3": "v3"}}, u)
+
+	var pe *PatchError
+	err := MergePatch(&u, []byte(`{"age":`))
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, http.StatusBadRequest, pe.Status)
+
+	err = MergePatch(&u, []byte(`{"age":"old"}`))
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, http.StatusUnprocessableEntity, pe.Status)
+	err = MergePatch(&u, []byte(`{"unknown":1}`))
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, http.StatusUnprocessableEntity, pe.Status)
+	assert.Equal(t, 31, u.Age, "target untouched on failure")
+}
+
+func TestJSONPatch(t *testing.T) {
+	tbl := []struct {
+		name  string
+		patch string
+		res   patchUser
+	}{
+		{"add to array", `[{"op":"add","path":"/tags/1","value":"x"},{"op":"add","path":"/tags/-","value":"z"}]`,
+			patchUser{Name: "bob", Age: 30, Tags: []string{"a", "x", "b", "z"}, Attrs: map[string]string{"a/b": "1"}}},
+		{"remove and replace", `[{"op":"remove","path":"/tags/0"},{"op":"replace","path":"/age","value":31}]`,
+			patchUser{Name: "bob", Age: 31, Tags: []string{"b"}, Attrs: map[string]string{"a/b": "1"}}},
+		{"escaped pointer", `[{"op":"replace","path":"/attrs/a~1b","value":"2"},{"op":"add","path":"/attrs/c~0d","value":"3"}]`,
+			patchUser{Name: "bob", Age: 30, Tags: []string{"a", "b"}, Attrs: map[string]string{"a/b": "2", "c~d": "3"}}},
+		{"move and copy", `[{"op":"copy","from":"/name","path":"/tags/0"},{"op":"move","from":"/attrs/a~1b","path":"/attrs/x"}]`,
+			patchUser{Name: "bob", Age: 30, Tags: []string{"bob", "a", "b"}, Attrs: map[string]string{"x": "1"}}},
+		{"test passes", `[{"op":"test","path":"/age","value":30.0},{"op":"test","path":"/tags","value":["a","b"]},` +
+			`{"op":"replace","path":"/name","value":"alice"}]`,
+			patchUser{Name: "alice", Age: 30, Tags: []string{"a", "b"}, Attrs: map[string]string{"a/b": "1"}}},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			u := patchUser{Name: "bob", Age: 30, Tags: []string{"a", "b"}, Attrs: map[string]string{"a/b": "1"}}
+			require.NoError(t, JSONPatch(&u, []byte(tt.patch)))
+			assert.Equal(t, tt.res, u)
+		})
+	}
+}
+
+func TestJSONPatch_NullValue(t *testing.T) {
+	doc := map[string]any{"a": "x", "b": "y"}
+	patch := `[{"op":"add","path":"/c","value":null},{"op":"replace","path":"/a","value":null},` +
+		`{"op":"test","path":"/a","value":null},{"op":"test","path":"/c","value":null}]`
+	require.NoError(t, JSONPatch(&doc, []byte(patch)))
+	assert.Equal(t, map[string]any{"a": nil, "b": "y", "c": nil}, doc)
+
+	err := JSONPatch(&doc, []byte(`[{"op":"test","path":"/b","value":null}]`))
+	require.ErrorIs(t, err, ErrPatchTestFailed, "null is compared as a value")
+	err = JSONPatch(&doc, []byte(`[{"op":"add","path":"/d"}]`))
+	var pe *PatchError
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, http.StatusBadRequest, pe.Status, "missing value still rejected")
+}
+
+func TestJSONPatch_Errors(t *testing.T) {
+	tbl := []struct {
+		name   string
+		patch  string
+		opts   []PatchOpt
+		status int
+		op     string
+	}{
+		{"not json", `[{"op":`, nil, http.StatusBadRequest, ""},
+		{"unknown op", `[{"op":"merge","path":"/age"}]`, nil, http.StatusBadRequest, "merge"},
+		{"missing value", `[{"op":"add","path":"/age"}]`, nil, http.StatusBadRequest, "add"},
+		{"missing from", `[{"op":"move","path":"/age"}]`, nil, http.StatusBadRequest, "move"},
+		{"bad pointer", `[{"op":"remove","path":"age"}]`, nil, http.StatusBadRequest, "remove"},
+		{"move into itself", `[{"op":"move","from":"/attrs","path":"/attrs/x"}]`, nil, http.StatusBadRequest, "move"},
+		{"missing path", `[{"op":"remove","path":"/attrs/nope"}]`, nil, http.StatusConflict, "remove"},
+		{"replace missing", `[{"op":"replace","path":"/attrs/nope","value":"x"}]`, nil, http.StatusConflict, "replace"},
+		{"index out of range", `[{"op":"add","path":"/tags/5","value":"x"}]`, nil, http.StatusConflict, "add"},
+		{"leading zero index", `[{"op":"remove","path":"/tags/01"}]`, nil, http.StatusConflict, "remove"},
+		{"test failed", `[{"op":"test","path":"/age","value":31}]`, nil, http.StatusConflict, "test"},
+		{"test failed as precondition", `[{"op":"test","path":"/age","value":31}]`,
+			[]PatchOpt{PatchTestAsPrecondition(true)}, http.StatusPreconditionFailed, "test"},
+		{"wrong result type", `[{"op":"replace","path":"/tags","value":"x"}]`, nil, http.StatusUnprocessableEntity, ""},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			u := patchUser{Name: "bob", Age: 30, Tags: []string{"a"}, Attrs: map[string]string{"k": "v"}}
+			// the failing op always comes after a successful one, to check the patch is atomic
+			patch := `[{"op":"replace","path":"/name","value":"changed"},` + strings.TrimPrefix(tt.patch, "[")
+			err := JSONPatch(&u, []byte(patch), tt.opts...)
+			var pe *PatchError
+			require.ErrorAs(t, err, &pe)
+			assert.Equal(t, tt.status, pe.Status)
+			assert.Equal(t, tt.op, pe.Op)
+			assert.Equal(t, "bob", u.Name)
+			if tt.op == "test" {
+				assert.ErrorIs(t, err, ErrPatchTestFailed)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := patchUser{Name: "bob", Age: 30}
+		if err := ApplyPatch(r, &u); err != nil {
+			var pe *PatchError
+			if errors.As(err, &pe) {
+				_ = EncodeJSON(w, pe.Status, JSON{"error": pe.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		RenderJSON(w, u)
+	})
+
+	tbl := []struct {
+		contentType string
+		body        string
+		status      int
+		res         string
+	}{
+		{"application/merge-patch+json", `{"age":31}`, http.StatusOK, `{"name":"bob","age":31}` + "\n"},
+		{"application/json-patch+json; charset=utf-8", `[{"op":"replace","path":"/name","value":"alice"}]`,
+			http.StatusOK, `{"name":"alice","age":30}` + "\n"},
+		{"application/json", `{"age":31}`, http.StatusUnsupportedMediaType, ""},
+		{"", `{"age":31}`, http.StatusUnsupportedMediaType, ""},
+		{"application/json-patch+json", `[{"op":"remove","path":"/nope"}]`, http.StatusConflict,
+			`{"error":"patch remove \"/nope\": path not found"}` + "\n"},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.contentType, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/user", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.res != "" {
+				assert.Equal(t, tt.res, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/go-pkgz/rest/logger"
+)
+
+const principalContextKey = "principal"
+
+// AuthBasic is the Method of principals authenticated by the BasicAuth family
+const AuthBasic = "basic"
+
+// Principal is the authenticated caller of a request, put to the context by auth middlewares
+type Principal struct {
+	Username string
+	Method   string   // how the caller was authenticated, like AuthBasic
+	Roles    []string // optional, empty if the auth method has none
+	Scopes   []string // optional, what the credentials allow, like the scopes of an API key, see RequireScope
+}
+
+// HasRole reports whether the principal has the role
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// HasScope reports whether the principal has the scope
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// GetPrincipal returns the authenticated caller of the request, false if no auth middleware authenticated it
+func GetPrincipal(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey(principalContextKey)).(Principal)
+	return p, ok
+}
+
+// withPrincipal returns the request with the principal in its context, and reports the username
+// to the logger middleware, if any, see logger.SetUser
+func withPrincipal(r *http.Request, p Principal) *http.Request {
+	logger.SetUser(r.Context(), p.Username)
+	return r.WithContext(context.WithValue(r.Context(), contextKey(principalContextKey), p))
+}
+
+// basicAuthorized returns the request authorized by BasicAuth for the user, with the IsAuthorized flag and the principal,
+// and reports the success to AuthGuard, if any
+func basicAuthorized(r *http.Request, user string) *http.Request {
+	reportAuthSuccess(r)
+	return authorized(r, Principal{Username: user, Method: AuthBasic})
+}
+
+// authorized returns the request with the IsAuthorized flag and the principal
+func authorized(r *http.Request, p Principal) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), contextKey(baContextKey), true))
+	return withPrincipal(r, p)
+}
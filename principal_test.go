@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-pkgz/rest/logger"
+)
+
+func TestGetPrincipal(t *testing.T) {
+	_, ok := GetPrincipal(context.Background())
+	assert.False(t, ok)
+
+	p := Principal{Username: "bob", Method: "jwt", Roles: []string{"admin"}}
+	req := withPrincipal(httptest.NewRequest("GET", "/", http.NoBody), p)
+	got, ok := GetPrincipal(req.Context())
+	require.True(t, ok)
+	assert.Equal(t, p, got)
+	assert.True(t, got.HasRole("admin"))
+	assert.False(t, got.HasRole("ops"))
+	assert.Empty(t, BasicAuthUser(req.Context()), "not basic auth")
+	assert.False(t, IsAuthorized(req.Context()))
+}
+
+func TestPrincipal_BasicAuthFamily(t *testing.T) {
+	hash, err := GenerateBcryptHash("passwd")
+	require.NoError(t, err)
+	argonHash, argonSalt, err := GenerateArgon2Hash("passwd")
+	require.NoError(t, err)
+
+	tbl := []struct {
+		name string
+		mw   func(http.Handler) http.Handler
+	}{
+		{"BasicAuth", BasicAuth(func(user, passwd string) bool { return user == "bob" && passwd == "passwd" })},
+		{"BasicAuthWithUserPasswd", BasicAuthWithUserPasswd("bob", "passwd")},
+		{"BasicAuthWithBcryptHash", BasicAuthWithBcryptHash("bob", hash)},
+		{"BasicAuthWithArgon2Hash", BasicAuthWithArgon2Hash("bob", argonHash, argonSalt)},
+		{"BasicAuthWithPrompt", BasicAuthWithPrompt("bob", "passwd")},
+		{"BasicAuthWithBcryptHashAndPrompt", BasicAuthWithBcryptHashAndPrompt("bob", hash)},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &mockLgr{}
+			lgr := logger.New(logger.Log(lb))
+			h := lgr.Handler(tt.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p, ok := GetPrincipal(r.Context())
+				require.True(t, ok)
+				assert.Equal(t, Principal{Username: "bob", Method: AuthBasic}, p)
+				assert.Equal(t, "bob", BasicAuthUser(r.Context()))
+				assert.True(t, IsAuthorized(r.Context()))
+			})))
+
+			req := httptest.NewRequest("GET", "/", http.NoBody)
+			req.SetBasicAuth("bob", "passwd")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, lb.buf.String(), " - bob\n", "user picked up by the logger")
+		})
+	}
+}
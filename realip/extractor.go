@@ -0,0 +1,155 @@
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Extractor gets the client's IP from forwarding headers, but only from requests coming through trusted proxies.
+// A request from any other peer could carry whatever headers the client made up, so its own address is used.
+type Extractor struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+// NewExtractor makes an Extractor trusting proxies in the given CIDRs or single addresses, like "10.0.0.0/8"
+// or "127.0.0.1". Headers are checked in order, the first one present wins; X-Forwarded-For is the default.
+// X-Forwarded-For and the for= nodes of RFC 7239 Forwarded are walked from the right, skipping trusted proxies,
+// to the first hop no trusted proxy could have been, which is the client. Any other header is taken as a single
+// address set by the proxy.
+func NewExtractor(trustedProxies []string, headers ...string) (*Extractor, error) {
+	res := &Extractor{headers: headers}
+	if len(res.headers) == 0 {
+		res.headers = []string{"X-Forwarded-For"}
+	}
+	for _, p := range trustedProxies {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		res.trusted = append(res.trusted, prefix)
+	}
+	return res, nil
+}
+
+// Get returns the client's IP of the request. A nil Extractor falls back to the package level Get.
+func (e *Extractor) Get(r *http.Request) (string, error) {
+	if e == nil {
+		return Get(r)
+	}
+	peer, err := parseAddr(r.RemoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("no valid ip found in %q", r.RemoteAddr)
+	}
+	if !e.Trusted(peer) {
+		return peer.String(), nil
+	}
+
+	for _, name := range e.headers {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if strings.EqualFold(name, "X-Forwarded-For") {
+			return e.forwardedFor(values, peer).String(), nil
+		}
+		if strings.EqualFold(name, "Forwarded") {
+			hops, err := ParseForwarded(values)
+			if err != nil {
+				continue
+			}
+			addrs := make([]netip.Addr, len(hops))
+			for i, hop := range hops {
+				addrs[i] = hop.ForAddr()
+			}
+			if i := e.clientHop(addrs); i >= 0 {
+				return addrs[i].String(), nil
+			}
+			return peer.String(), nil
+		}
+		if addr, err := parseAddr(values[0]); err == nil {
+			return addr.String(), nil
+		}
+	}
+	return peer.String(), nil
+}
+
+// Trusted reports whether the address belongs to a trusted proxy
+func (e *Extractor) Trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range e.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client's address from X-Forwarded-For values, or the peer if there is none
+func (e *Extractor) forwardedFor(values []string, peer netip.Addr) netip.Addr {
+	hops := strings.Split(strings.Join(values, ","), ",")
+	addrs := make([]netip.Addr, len(hops))
+	for i, hop := range hops {
+		if addr, err := parseAddr(hop); err == nil {
+			addrs[i] = addr
+		}
+	}
+	if i := e.clientHop(addrs); i >= 0 {
+		return addrs[i]
+	}
+	return peer
+}
+
+// clientHop walks the hops from the right, each one added by the proxy next to it, and returns the index of
+// the first one not trusted. A hop that isn't a valid address ends the walk at the trusted proxy which passed
+// it on, -1 if that is the peer itself.
+func (e *Extractor) clientHop(addrs []netip.Addr) int {
+	res := -1
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !addrs[i].IsValid() {
+			return res
+		}
+		res = i
+		if !e.Trusted(addrs[i]) {
+			return i
+		}
+	}
+	return res // all hops are trusted, the leftmost one is as far as it goes
+}
+
+// parseAddr parses an address with or without a port, IPv6 possibly in brackets, and unmaps IPv4-mapped IPv6
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// parsePrefix parses a CIDR or a single address, taken as a prefix of its full length
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
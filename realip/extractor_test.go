@@ -0,0 +1,102 @@
+package realip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractor_Get(t *testing.T) {
+	e, err := NewExtractor([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		wantIP     string
+	}{
+		{name: "untrusted peer ignores headers", remoteAddr: "8.8.8.8:1234", xff: []string{"1.1.1.1"}, wantIP: "8.8.8.8"},
+		{name: "trusted peer without headers", remoteAddr: "10.1.1.1:1234", wantIP: "10.1.1.1"},
+		{name: "trusted peer single hop", remoteAddr: "10.1.1.1:1234", xff: []string{"1.1.1.1"}, wantIP: "1.1.1.1"},
+		{name: "spoofed leftmost hop skipped", remoteAddr: "10.1.1.1:1234", xff: []string{"6.6.6.6, 1.1.1.1"},
+			wantIP: "1.1.1.1"},
+		{name: "trusted hops skipped", remoteAddr: "10.1.1.1:1234", xff: []string{"6.6.6.6, 1.1.1.1, 192.168.1.1, 10.2.2.2"},
+			wantIP: "1.1.1.1"},
+		{name: "private untrusted hop is the client", remoteAddr: "10.1.1.1:1234", xff: []string{"1.1.1.1, 172.16.0.5"},
+			wantIP: "172.16.0.5"},
+		{name: "repeated headers joined", remoteAddr: "10.1.1.1:1234", xff: []string{"6.6.6.6", "1.1.1.1, 10.2.2.2"},
+			wantIP: "1.1.1.1"},
+		{name: "all hops trusted", remoteAddr: "10.1.1.1:1234", xff: []string{"10.3.3.3, 10.2.2.2"}, wantIP: "10.3.3.3"},
+		{name: "garbage stops the walk", remoteAddr: "10.1.1.1:1234", xff: []string{"1.1.1.1, garbage, 10.2.2.2"},
+			wantIP: "10.2.2.2"},
+		{name: "hop with port", remoteAddr: "10.1.1.1:1234", xff: []string{"1.1.1.1:5555"}, wantIP: "1.1.1.1"},
+		{name: "ipv6 peer and hop", remoteAddr: "[fd00::1]:1234", xff: []string{"[2001:db8::1]:80"}, wantIP: "2001:db8::1"},
+		{name: "ipv4-mapped peer", remoteAddr: "[::ffff:10.1.1.1]:1234", xff: []string{"1.1.1.1"}, wantIP: "1.1.1.1"},
+		{name: "x-real-ip not configured", remoteAddr: "10.1.1.1:1234", realIP: "1.1.1.1", wantIP: "10.1.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", http.NoBody)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			ip, err := e.Get(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIP, ip)
+		})
+	}
+}
+
+func TestExtractor_Headers(t *testing.T) {
+	e, err := NewExtractor([]string{"127.0.0.1"}, "CF-Connecting-IP", "X-Real-IP")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", http.NoBody)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+	req.Header.Set("X-Real-IP", "2.2.2.2")
+	ip, err := e.Get(req)
+	require.NoError(t, err)
+	assert.Equal(t, "2.2.2.2", ip)
+
+	req.Header.Set("CF-Connecting-IP", "1.1.1.1")
+	ip, err = e.Get(req)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", ip, "headers checked in order")
+
+	req.Header.Set("CF-Connecting-IP", "bad")
+	ip, err = e.Get(req)
+	require.NoError(t, err)
+	assert.Equal(t, "2.2.2.2", ip, "invalid value skipped")
+}
+
+func TestExtractor_Errors(t *testing.T) {
+	_, err := NewExtractor([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+	_, err = NewExtractor([]string{"proxy.local"})
+	require.Error(t, err)
+
+	e, err := NewExtractor(nil)
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/", http.NoBody)
+	req.RemoteAddr = "bad-addr"
+	_, err = e.Get(req)
+	require.Error(t, err)
+
+	var nilExtractor *Extractor
+	req.RemoteAddr = "1.2.3.4:1234"
+	req.Header.Set("X-Real-IP", "8.8.8.8")
+	ip, err := nilExtractor.Get(req)
+	require.NoError(t, err)
+	assert.Equal(t, "8.8.8.8", ip, "nil extractor works as Get")
+}
@@ -0,0 +1,254 @@
+package realip
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ForwardedHop is an element of the RFC 7239 Forwarded header, added by a single proxy. For and By are nodes
+// as sent, unquoted: an address with an optional port, IPv6 in brackets, "unknown" or an obfuscated
+// identifier starting with "_". Parameters a proxy didn't send are empty.
+type ForwardedHop struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+// ForAddr returns the address of the For node, invalid for unknown and obfuscated nodes
+func (h ForwardedHop) ForAddr() netip.Addr {
+	return nodeAddr(h.For)
+}
+
+// ByAddr returns the address of the By node, invalid for unknown and obfuscated nodes
+func (h ForwardedHop) ByAddr() netip.Addr {
+	return nodeAddr(h.By)
+}
+
+// ParseForwarded parses Forwarded header values into hops, the client facing proxy's first. Repeated headers
+// form a single list. Parameter names are case-insensitive, values may be quoted, unknown parameters are
+// ignored. Optional whitespace around separators is tolerated, broken quoting and parameters without a
+// value are not.
+func ParseForwarded(values []string) ([]ForwardedHop, error) {
+	var res []ForwardedHop
+	for _, v := range values {
+		hop, inHop := ForwardedHop{}, false
+		rest := v
+		for {
+			rest = strings.TrimLeft(rest, " \t")
+			if rest == "" {
+				break
+			}
+			if rest[0] == ',' || rest[0] == ';' {
+				if rest[0] == ',' && inHop {
+					res = append(res, hop)
+					hop, inHop = ForwardedHop{}, false
+				}
+				rest = rest[1:]
+				continue
+			}
+
+			eq := strings.IndexByte(rest, '=')
+			if eq <= 0 || strings.ContainsAny(rest[:eq], ",; \t\"") {
+				return nil, fmt.Errorf("invalid forwarded pair in %q", v)
+			}
+			name := strings.ToLower(rest[:eq])
+			value, tail, err := forwardedValue(rest[eq+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid forwarded %s in %q: %w", name, v, err)
+			}
+			rest = tail
+
+			switch name {
+			case "for":
+				hop.For = value
+			case "by":
+				hop.By = value
+			case "host":
+				hop.Host = value
+			case "proto":
+				hop.Proto = strings.ToLower(value)
+			}
+			inHop = true
+		}
+		if inHop {
+			res = append(res, hop)
+		}
+	}
+	return res, nil
+}
+
+// forwardedValue reads a token or a quoted string, returning the rest of the input after it
+func forwardedValue(s string) (value, rest string, err error) {
+	if s == "" || s[0] != '"' {
+		end := strings.IndexAny(s, ",;")
+		if end < 0 {
+			end = len(s)
+		}
+		value = strings.TrimRight(s[:end], " \t")
+		if value == "" || strings.ContainsAny(value, " \t\"") {
+			return "", "", errors.New("bad token")
+		}
+		return value, s[end:], nil
+	}
+
+	var bld strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 == len(s) {
+				return "", "", errors.New("unterminated quoted string")
+			}
+			i++
+			bld.WriteByte(s[i])
+		case '"':
+			return bld.String(), s[i+1:], nil
+		default:
+			bld.WriteByte(s[i])
+		}
+	}
+	return "", "", errors.New("unterminated quoted string")
+}
+
+// nodeAddr parses a node of the Forwarded header, "192.0.2.43", "192.0.2.43:47011" or "[2001:db8::1]:80"
+func nodeAddr(node string) netip.Addr {
+	if node == "" || strings.EqualFold(node, "unknown") || strings.HasPrefix(node, "_") {
+		return netip.Addr{}
+	}
+	addr, err := parseAddr(node)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// Scheme returns the scheme the client used, "http" or "https", taken from the first Forwarded hop, then
+// X-Forwarded-Proto, then the connection itself; header values other than "http" and "https" are ignored.
+// Like Get, it believes the headers of any peer, so it is only safe behind a proxy overwriting them; use
+// Extractor.Scheme otherwise.
+func Scheme(r *http.Request) string {
+	if hops, err := ParseForwarded(r.Header.Values("Forwarded")); err == nil && len(hops) > 0 {
+		if proto := webScheme(hops[0].Proto); proto != "" {
+			return proto
+		}
+	}
+	if proto := webScheme(firstListValue(r.Header.Values("X-Forwarded-Proto"))); proto != "" {
+		return proto
+	}
+	return connScheme(r)
+}
+
+// Host returns the host the client asked for, taken from the first Forwarded hop, then X-Forwarded-Host,
+// then the request itself. Like Get, it believes the headers of any peer, so it is only safe behind a proxy
+// overwriting them; use Extractor.Host otherwise.
+func Host(r *http.Request) string {
+	if hops, err := ParseForwarded(r.Header.Values("Forwarded")); err == nil && len(hops) > 0 && hops[0].Host != "" {
+		return hops[0].Host
+	}
+	if host := firstListValue(r.Header.Values("X-Forwarded-Host")); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// Scheme returns the scheme the client used, "http" or "https". For a request through trusted proxies it is
+// taken from the Forwarded hop of the client, or else from the last X-Forwarded-Proto value, the one set by a
+// trusted proxy; header values other than "http" and "https" are ignored. Otherwise it is the scheme of the
+// connection.
+func (e *Extractor) Scheme(r *http.Request) string {
+	if e == nil {
+		return Scheme(r)
+	}
+	if hop, ok := e.forwardedHop(r); ok {
+		if proto := webScheme(hop.Proto); proto != "" {
+			return proto
+		}
+	}
+	if e.trustedPeer(r) {
+		if proto := webScheme(lastListValue(r.Header.Values("X-Forwarded-Proto"))); proto != "" {
+			return proto
+		}
+	}
+	return connScheme(r)
+}
+
+// Host returns the host the client asked for. For a request through trusted proxies it is taken from the
+// Forwarded hop of the client, or else from the last X-Forwarded-Host value, the one set by a trusted proxy.
+// Otherwise it is the Host of the request.
+func (e *Extractor) Host(r *http.Request) string {
+	if e == nil {
+		return Host(r)
+	}
+	if hop, ok := e.forwardedHop(r); ok && hop.Host != "" {
+		return hop.Host
+	}
+	if e.trustedPeer(r) {
+		if host := lastListValue(r.Header.Values("X-Forwarded-Host")); host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// forwardedHop returns the Forwarded hop added by the proxy the client connected to, the one the client ip
+// comes from, provided the request came through trusted proxies
+func (e *Extractor) forwardedHop(r *http.Request) (ForwardedHop, bool) {
+	if !e.trustedPeer(r) {
+		return ForwardedHop{}, false
+	}
+	hops, err := ParseForwarded(r.Header.Values("Forwarded"))
+	if err != nil || len(hops) == 0 {
+		return ForwardedHop{}, false
+	}
+	// unlike the client's ip, an unknown or obfuscated for= node doesn't end the walk early, the hop holding it
+	// was still added by a trusted proxy
+	for i := len(hops) - 1; i > 0; i-- {
+		if addr := hops[i].ForAddr(); !addr.IsValid() || !e.Trusted(addr) {
+			return hops[i], true
+		}
+	}
+	return hops[0], true
+}
+
+func (e *Extractor) trustedPeer(r *http.Request) bool {
+	peer, err := parseAddr(r.RemoteAddr)
+	return err == nil && e.Trusted(peer)
+}
+
+func connScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// webScheme returns the proto lowercased if it is "http" or "https", empty otherwise
+func webScheme(proto string) string {
+	switch proto = strings.ToLower(proto); proto {
+	case "http", "https":
+		return proto
+	}
+	return ""
+}
+
+func firstListValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	first, _, _ := strings.Cut(values[0], ",")
+	return strings.TrimSpace(first)
+}
+
+func lastListValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	last := values[len(values)-1]
+	if i := strings.LastIndexByte(last, ','); i >= 0 {
+		last = last[i+1:]
+	}
+	return strings.TrimSpace(last)
+}
@@ -0,0 +1,129 @@
+package realip
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		hops   []ForwardedHop
+		err    bool
+	}{
+		{name: "rfc example", values: []string{"for=192.0.2.60;proto=http;by=203.0.113.43"},
+			hops: []ForwardedHop{{For: "192.0.2.60", By: "203.0.113.43", Proto: "http"}}},
+		{name: "quoted ipv6 with port", values: []string{`For="[2001:db8:cafe::17]:4711"`},
+			hops: []ForwardedHop{{For: "[2001:db8:cafe::17]:4711"}}},
+		{name: "multiple hops", values: []string{"for=192.0.2.43, for=198.51.100.17;host=example.com"},
+			hops: []ForwardedHop{{For: "192.0.2.43"}, {For: "198.51.100.17", Host: "example.com"}}},
+		{name: "repeated headers", values: []string{"for=192.0.2.43", `for="_hidden";proto=HTTPS`},
+			hops: []ForwardedHop{{For: "192.0.2.43"}, {For: "_hidden", Proto: "https"}}},
+		{name: "spaces and unknown params", values: []string{" for=unknown ; secret=x ;  proto=https , "},
+			hops: []ForwardedHop{{For: "unknown", Proto: "https"}}},
+		{name: "escaped quote", values: []string{`host="a\"b"`}, hops: []ForwardedHop{{Host: `a"b`}}},
+		{name: "empty", values: []string{""}},
+		{name: "no value", values: []string{"for=;proto=https"}, err: true},
+		{name: "no pair", values: []string{"for=1.2.3.4;https"}, err: true},
+		{name: "unterminated quote", values: []string{`for="[2001:db8::1]`}, err: true},
+		{name: "space inside token", values: []string{"for=1.2.3.4 5.6.7.8"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hops, err := ParseForwarded(tt.values)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.hops, hops)
+		})
+	}
+}
+
+func TestForwardedHop_Addr(t *testing.T) {
+	assert.Equal(t, "2001:db8:cafe::17", ForwardedHop{For: "[2001:db8:cafe::17]:4711"}.ForAddr().String())
+	assert.Equal(t, "192.0.2.43", ForwardedHop{For: "192.0.2.43:47011"}.ForAddr().String())
+	assert.Equal(t, "203.0.113.43", ForwardedHop{By: "203.0.113.43"}.ByAddr().String())
+	assert.False(t, ForwardedHop{For: "unknown"}.ForAddr().IsValid())
+	assert.False(t, ForwardedHop{For: "_hidden"}.ForAddr().IsValid())
+	assert.False(t, ForwardedHop{}.ByAddr().IsValid())
+}
+
+func TestSchemeAndHost(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://internal:8080/", http.NoBody)
+	assert.Equal(t, "http", Scheme(req))
+	assert.Equal(t, "internal:8080", Host(req))
+
+	req.Header.Set("X-Forwarded-Proto", "HTTPS")
+	req.Header.Set("X-Forwarded-Host", "example.com, internal")
+	assert.Equal(t, "https", Scheme(req))
+	assert.Equal(t, "example.com", Host(req))
+
+	req.Header.Set("Forwarded", `for=1.1.1.1;proto=http;host="api.example.com", for=10.0.0.1;proto=https`)
+	assert.Equal(t, "http", Scheme(req), "forwarded wins")
+	assert.Equal(t, "api.example.com", Host(req))
+
+	req.Header.Set("Forwarded", `for=1.1.1.1;proto=javascript`)
+	assert.Equal(t, "https", Scheme(req), "not a web scheme, x-forwarded-proto used")
+	req.Header.Set("X-Forwarded-Proto", "javascript")
+	assert.Equal(t, "http", Scheme(req), "scheme of the connection")
+
+	req = httptest.NewRequest("GET", "/", http.NoBody)
+	req.TLS = &tls.ConnectionState{}
+	assert.Equal(t, "https", Scheme(req))
+}
+
+func TestExtractor_Forwarded(t *testing.T) {
+	e, err := NewExtractor([]string{"10.0.0.0/8"}, "Forwarded", "X-Forwarded-For")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		remoteAddr       string
+		forwarded        string
+		xfp, xfh         string
+		ip, scheme, host string
+	}{
+		{name: "untrusted peer", remoteAddr: "8.8.8.8:1234", forwarded: "for=1.1.1.1;proto=https;host=evil.com",
+			ip: "8.8.8.8", scheme: "http", host: "example.com"},
+		{name: "single hop", remoteAddr: "10.0.0.1:1234", forwarded: `for="[2001:db8::1]:4711";proto=https;host=api.com`,
+			ip: "2001:db8::1", scheme: "https", host: "api.com"},
+		{name: "spoofed hop skipped", remoteAddr: "10.0.0.1:1234", ip: "1.1.1.1", scheme: "https", host: "api.com",
+			forwarded: "for=6.6.6.6;proto=http;host=evil.com, for=1.1.1.1;proto=https;host=api.com, for=10.0.0.2"},
+		{name: "obfuscated client", remoteAddr: "10.0.0.1:1234", forwarded: "for=_hidden;proto=https;host=api.com",
+			ip: "10.0.0.1", scheme: "https", host: "api.com"},
+		{name: "x-forwarded fallback", remoteAddr: "10.0.0.1:1234", xfp: "http, https", xfh: "evil.com, api.com",
+			ip: "10.0.0.1", scheme: "https", host: "api.com"},
+		{name: "broken forwarded", remoteAddr: "10.0.0.1:1234", forwarded: `for="1.1.1.1`,
+			ip: "10.0.0.1", scheme: "http", host: "example.com"},
+		{name: "not a web scheme", remoteAddr: "10.0.0.1:1234", forwarded: "for=1.1.1.1;proto=javascript",
+			xfp: "javascript", xfh: "api.com", ip: "1.1.1.1", scheme: "http", host: "api.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", http.NoBody)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("Forwarded", tt.forwarded)
+			}
+			if tt.xfp != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.xfp)
+				req.Header.Set("X-Forwarded-Host", tt.xfh)
+			}
+			ip, err := e.Get(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.ip, ip)
+			assert.Equal(t, tt.scheme, e.Scheme(req))
+			assert.Equal(t, tt.host, e.Host(req))
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package realip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLen is the longest v1 header line, CRLF included, allowed by the spec
+const proxyV1MaxLen = 107
+
+// ProxyListener is a net.Listener for servers behind TCP load balancers speaking HAProxy PROXY protocol,
+// v1 or v2. Connections from trusted sources must start with a PROXY header, and the client address from
+// it becomes the RemoteAddr of the connection, so realip.Get and everything else see the real client.
+// Connections from other sources are passed through untouched, any header they send is just data.
+type ProxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+// NewProxyListener wraps the listener, accepting PROXY headers from the trusted sources, CIDRs or single
+// addresses like "10.0.0.0/8" or "127.0.0.1". Like NewExtractor, it trusts only the sources given, and as
+// a listener trusting nobody would be of no use, at least one is required; pass "0.0.0.0/0" and "::/0" to
+// trust any peer, for a port reachable by the load balancer only. The header is read lazily, on the first
+// use of the connection, so a slow peer can't hold up Accept; the timeout limits how long the read may take,
+// zero means no limit.
+func NewProxyListener(ln net.Listener, timeout time.Duration, trustedSources ...string) (*ProxyListener, error) {
+	if len(trustedSources) == 0 {
+		return nil, errors.New("no trusted sources, use \"0.0.0.0/0\" and \"::/0\" to trust any peer")
+	}
+	res := &ProxyListener{Listener: ln, timeout: timeout}
+	for _, s := range trustedSources {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted source %q: %w", s, err)
+		}
+		res.trusted = append(res.trusted, prefix)
+	}
+	return res, nil
+}
+
+// Accept waits for the next connection, wrapping it to read the PROXY header if it comes from a trusted source
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trustedSource(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+func (l *ProxyListener) trustedSource(addr net.Addr) bool {
+	src, err := parseAddr(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, p := range l.trusted {
+		if p.Contains(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the PROXY header on its first use and serves the rest of the stream from the buffered reader
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the peer's if the header has none
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the PROXY header, or the listener's if the header has none
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	c.reader = bufio.NewReaderSize(c.Conn, 256)
+	if c.timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			c.err = err
+			return
+		}
+		defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+	}
+	c.remote, c.local, c.err = readProxyHeader(c.reader)
+}
+
+// readProxyHeader reads a v1 or v2 PROXY header, returning nil addresses for LOCAL and UNKNOWN connections,
+// the ones the balancer makes itself, like health checks
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	if prefix, _ := r.Peek(5); string(prefix) == "PROXY" {
+		return readProxyV1(r)
+	}
+	if prefix, _ := r.Peek(len(proxyV2Signature)); bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	return nil, nil, errors.New("proxy protocol: no header")
+}
+
+// readProxyV1 reads a text header, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func readProxyV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > proxyV1MaxLen || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("proxy protocol: invalid v1 header line")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxy protocol: invalid v1 header %q", line)
+	}
+
+	parse := func(ip, port string) (net.Addr, error) {
+		addr, aerr := netip.ParseAddr(ip)
+		if aerr != nil || addr.Is4() != (fields[1] == "TCP4") {
+			return nil, fmt.Errorf("proxy protocol: invalid v1 address %q", ip)
+		}
+		p, perr := strconv.ParseUint(port, 10, 16)
+		if perr != nil {
+			return nil, fmt.Errorf("proxy protocol: invalid v1 port %q", port)
+		}
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+	}
+	if src, err = parse(fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = parse(fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+// readProxyV2 reads a binary header: the signature, version and command, family and transport, payload length,
+// then the addresses followed by TLVs, which are skipped
+func readProxyV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	hdr := make([]byte, 16)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: short v2 header: %w", err)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported v2 version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: short v2 payload: %w", err)
+	}
+
+	switch hdr[12] & 0x0f {
+	case 0x0: // LOCAL, a connection of the balancer itself
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported v2 command %d", hdr[12]&0x0f)
+	}
+
+	var size int
+	switch hdr[13] >> 4 {
+	case 0x1: // AF_INET
+		size = 4
+	case 0x2: // AF_INET6
+		size = 16
+	default: // AF_UNSPEC and AF_UNIX carry no ip
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, errors.New("proxy protocol: v2 payload too short for addresses")
+	}
+	srcIP, _ := netip.AddrFromSlice(payload[:size])
+	dstIP, _ := netip.AddrFromSlice(payload[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(payload[2*size:])
+	dstPort := binary.BigEndian.Uint16(payload[2*size+2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP.Unmap(), srcPort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP.Unmap(), dstPort)), nil
+}
@@ -0,0 +1,169 @@
+package realip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyV2Header(cmd, fam byte, addrs []byte) []byte {
+	res := append([]byte{}, proxyV2Signature...)
+	res = append(res, 0x20|cmd, fam)
+	res = binary.BigEndian.AppendUint16(res, uint16(len(addrs)))
+	return append(res, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xdc, 0x04, 0x01, 0xbb)
+
+	tests := []struct {
+		name     string
+		input    []byte
+		src, dst string
+		err      string
+	}{
+		{name: "v1 tcp4", input: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET"),
+			src: "192.0.2.1:56324", dst: "192.0.2.2:443"},
+		{name: "v1 tcp6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET"),
+			src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{name: "v1 unknown", input: []byte("PROXY UNKNOWN\r\nGET")},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n"), err: "invalid v1 address"},
+		{name: "v1 bad port", input: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 70000 443\r\n"), err: "invalid v1 port"},
+		{name: "v1 missing fields", input: []byte("PROXY TCP4 192.0.2.1\r\n"), err: "invalid v1 header"},
+		{name: "v1 no crlf", input: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 1 2\nGET"), err: "invalid v1 header line"},
+		{name: "v1 too long", input: []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), err: "invalid v1 header line"},
+		{name: "v2 tcp4 with tlv", input: append(proxyV2Header(1, 0x11, append(v4, 0x04, 0x00, 0x01, 'x')), "GET"...),
+			src: "192.0.2.1:56324", dst: "192.0.2.2:443"},
+		{name: "v2 tcp6", input: append(proxyV2Header(1, 0x21, v6), "GET"...),
+			src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{name: "v2 local", input: append(proxyV2Header(0, 0x00, nil), "GET"...)},
+		{name: "v2 unix", input: append(proxyV2Header(1, 0x31, make([]byte, 216)), "GET"...)},
+		{name: "v2 short addresses", input: proxyV2Header(1, 0x11, v4[:8]), err: "too short"},
+		{name: "v2 bad command", input: proxyV2Header(2, 0x11, v4), err: "unsupported v2 command"},
+		{name: "v2 short payload", input: proxyV2Header(1, 0x11, v4)[:20], err: "short v2 payload"},
+		{name: "no header", input: []byte("GET / HTTP/1.1\r\n\r\n"), err: "no header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.input))
+			src, dst, err := readProxyHeader(r)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.src == "" {
+				assert.Nil(t, src)
+				assert.Nil(t, dst)
+			} else {
+				assert.Equal(t, tt.src, src.String())
+				assert.Equal(t, tt.dst, dst.String())
+			}
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "GET", string(rest), "stream continues after the header")
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pln, err := NewProxyListener(ln, time.Second, "127.0.0.1")
+	require.NoError(t, err)
+
+	srv := &http.Server{ReadHeaderTimeout: time.Second, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := Get(r)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(r.RemoteAddr + " " + ip))
+	})}
+	go func() { _ = srv.Serve(pln) }()
+	defer srv.Close()
+
+	send := func(header string) string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(header + "GET / HTTP/1.0\r\nHost: example.com\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(resp)
+	}
+
+	resp := send("PROXY TCP4 203.0.113.7 192.0.2.2 56324 443\r\n")
+	assert.Contains(t, resp, "200 OK")
+	assert.True(t, strings.HasSuffix(resp, "203.0.113.7:56324 203.0.113.7"), resp)
+
+	resp = send("PROXY UNKNOWN\r\n")
+	assert.Contains(t, resp, "200 OK")
+	assert.Contains(t, resp, "127.0.0.1:", "balancer's own connection keeps its address")
+
+	resp = send("")
+	assert.Contains(t, resp, "400 Bad Request", "trusted source without a header is rejected")
+}
+
+func TestProxyListener_Untrusted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	pln, err := NewProxyListener(ln, time.Second, "10.0.0.0/8")
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.2 56324 443\r\n"))
+	}()
+
+	conn, err := pln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1:")
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY TCP4 203.0.113.7 192.0.2.2 56324 443\r\n", string(data), "header from untrusted source is data")
+}
+
+func TestProxyListener_Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	pln, err := NewProxyListener(ln, 50*time.Millisecond, "0.0.0.0/0", "::/0") // any peer trusted explicitly
+	require.NoError(t, err)
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROX"))
+	require.NoError(t, err)
+
+	conn, err := pln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 10))
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1:")
+
+	_, err = NewProxyListener(ln, 0, "bad")
+	require.Error(t, err)
+	_, err = NewProxyListener(ln, 0)
+	require.Error(t, err, "no trusted sources doesn't mean any source")
+}
@@ -38,7 +38,8 @@ var privateRanges = []ipRange{
 //  1. X-Real-IP - trusted proxy (nginx/reproxy) sets this to actual client
 //  2. CF-Connecting-IP - Cloudflare's header for original client
 //  3. X-Forwarded-For - leftmost public IP (original client in CDN chain)
-//  4. RemoteAddr - fallback for direct connections
+//  4. Forwarded - leftmost public for= address (RFC 7239)
+//  5. RemoteAddr - fallback for direct connections
 //
 // Only public IPs are accepted from headers; private/loopback/link-local IPs are skipped.
 func Get(r *http.Request) (string, error) {
@@ -66,6 +67,15 @@ func Get(r *http.Request) (string, error) {
 		}
 	}
 
+	// check RFC 7239 Forwarded, find leftmost public for= address
+	if hops, err := ParseForwarded(r.Header.Values("Forwarded")); err == nil {
+		for _, hop := range hops {
+			if addr := hop.ForAddr(); addr.IsValid() && isPublicIP(net.IP(addr.AsSlice())) {
+				return addr.String(), nil
+			}
+		}
+	}
+
 	// fall back to RemoteAddr
 	return parseRemoteAddr(r.RemoteAddr)
 }
@@ -49,6 +49,17 @@ func TestGet(t *testing.T) {
 		{name: "XFF IPv6 mixed", headers: map[string]string{"X-Forwarded-For": "::1, fc00::1, 2001:db8::1"}, wantIP: "2001:db8::1"},
 		{name: "XFF invalid entries skipped", headers: map[string]string{"X-Forwarded-For": "not-an-ip, 8.8.8.8, garbage"}, wantIP: "8.8.8.8"},
 
+		// forwarded tests (fourth priority, leftmost public for=)
+		{name: "Forwarded public", headers: map[string]string{"Forwarded": "for=8.8.8.8;proto=https"}, wantIP: "8.8.8.8"},
+		{name: "Forwarded quoted IPv6 with port", headers: map[string]string{"Forwarded": `for="[2001:db8::1]:4711"`},
+			wantIP: "2001:db8::1"},
+		{name: "Forwarded private and obfuscated skipped",
+			headers: map[string]string{"Forwarded": "for=_hidden, for=10.0.0.1, for=unknown, for=8.8.8.8"}, wantIP: "8.8.8.8"},
+		{name: "Forwarded malformed falls through", remoteAddr: "1.2.3.4:1234",
+			headers: map[string]string{"Forwarded": `for="8.8.8.8`}, wantIP: "1.2.3.4"},
+		{name: "XFF takes priority over Forwarded",
+			headers: map[string]string{"X-Forwarded-For": "5.6.7.8", "Forwarded": "for=8.8.8.8"}, wantIP: "5.6.7.8"},
+
 		// header priority tests
 		{name: "X-Real-IP takes priority over XFF", headers: map[string]string{"X-Real-IP": "1.2.3.4", "X-Forwarded-For": "5.6.7.8"}, wantIP: "1.2.3.4"},
 		{name: "X-Real-IP takes priority over CF-Connecting-IP", headers: map[string]string{"X-Real-IP": "1.2.3.4", "CF-Connecting-IP": "5.6.7.8"}, wantIP: "1.2.3.4"},
@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNotAcceptable is returned by Render when none of the registered encoders matches the Accept header
+var ErrNotAcceptable = errors.New("not acceptable")
+
+// EncoderFunc writes v to w in the format of the media type it is registered for
+type EncoderFunc func(w io.Writer, v any) error
+
+// CSVMarshaler is implemented by values able to present themselves as csv records
+type CSVMarshaler interface {
+	MarshalCSV() ([][]string, error)
+}
+
+// Renderer encodes responses in the format picked from the request's Accept header, using a registry
+// of encoders keyed by media type. The first registered encoder is the server's preferred one, used
+// when the request has no Accept header or accepts anything.
+type Renderer struct {
+	mu       sync.RWMutex
+	encoders []mediaEncoder
+}
+
+type mediaEncoder struct {
+	mediaType   string // lowercased type/subtype, used for matching
+	contentType string // full value for the Content-Type header
+	fn          EncoderFunc
+}
+
+// defaultRenderer backs the package level Render
+var defaultRenderer = NewRenderer()
+
+// NewRenderer makes a Renderer with json (preferred), xml, csv and plain text encoders registered
+func NewRenderer() *Renderer {
+	res := &Renderer{}
+	res.Register("application/json; charset=utf-8", encodeJSONValue)
+	res.Register("application/xml; charset=utf-8", encodeXMLValue)
+	res.Register("text/csv; charset=utf-8", encodeCSVValue)
+	res.Register("text/plain; charset=utf-8", encodeTextValue)
+	return res
+}
+
+// Register adds an encoder for the given content type, like "application/yaml" or "text/csv; charset=utf-8".
+// Parameters are sent with the Content-Type header but not used for matching. Registering a media type
+// again replaces its encoder and keeps its position in the preference order.
+func (rr *Renderer) Register(contentType string, fn EncoderFunc) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for i, e := range rr.encoders {
+		if e.mediaType == mediaType {
+			rr.encoders[i] = mediaEncoder{mediaType: mediaType, contentType: contentType, fn: fn}
+			return
+		}
+	}
+	rr.encoders = append(rr.encoders, mediaEncoder{mediaType: mediaType, contentType: contentType, fn: fn})
+}
+
+// Render encodes v with the encoder picked from the request's Accept header and sends it with the given
+// status. Vary: Accept is always added, as the representation depends on it. When nothing registered is
+// acceptable it answers StatusNotAcceptable (406) and returns ErrNotAcceptable. Like EncodeJSON, the value
+// is encoded before anything is written, so an encoding failure leaves the response uncommitted.
+func (rr *Renderer) Render(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	w.Header().Add("Vary", "Accept")
+
+	enc, ok := rr.negotiate(r.Header.Values("Accept"))
+	if !ok {
+		http.Error(w, "not acceptable, available: "+strings.Join(rr.mediaTypes(), ", "), http.StatusNotAcceptable)
+		return fmt.Errorf("%w: %q", ErrNotAcceptable, strings.Join(r.Header.Values("Accept"), ", "))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := enc.fn(buf, v); err != nil {
+		return fmt.Errorf("encode %s: %w", enc.mediaType, err)
+	}
+	w.Header().Set("Content-Type", enc.contentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write %s: %w", enc.mediaType, err)
+	}
+	return nil
+}
+
+// Render sends v in the format the client asked for in the Accept header, picking from json, xml, csv and
+// plain text. See Renderer.Render for details, and NewRenderer to register other formats.
+func Render(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	return defaultRenderer.Render(w, r, status, v)
+}
+
+// negotiate picks the encoder with the highest quality in the Accept header. Every encoder gets the
+// quality of the most specific range matching it, so "text/*;q=0, text/csv" still allows csv. Ties
+// go to the encoder registered first. A missing Accept header accepts anything.
+func (rr *Renderer) negotiate(headers []string) (mediaEncoder, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	if len(rr.encoders) == 0 {
+		return mediaEncoder{}, false
+	}
+	ranges := parseAccept(headers)
+	if len(ranges) == 0 {
+		return rr.encoders[0], true
+	}
+
+	var best mediaEncoder
+	bestQ := 0.0
+	for _, e := range rr.encoders {
+		q, specificity := 0.0, -1
+		for _, ar := range ranges {
+			if s := ar.matches(e.mediaType); s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+func (rr *Renderer) mediaTypes() []string {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	res := make([]string, 0, len(rr.encoders))
+	for _, e := range rr.encoders {
+		res = append(res, e.mediaType)
+	}
+	return res
+}
+
+// acceptRange is a single media range of the Accept header
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// matches reports how specifically the range matches the media type: 2 for an exact match, 1 for
+// type/*, 0 for */* and -1 for no match at all
+func (ar acceptRange) matches(mediaType string) int {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	switch {
+	case ar.typ == "*" && ar.subtype == "*":
+		return 0
+	case ar.typ == typ && ar.subtype == "*":
+		return 1
+	case ar.typ == typ && ar.subtype == subtype:
+		return 2
+	}
+	return -1
+}
+
+// parseAccept parses Accept header fields into media ranges, skipping malformed entries.
+// Repeated fields form a single list, so all of them are examined.
+func parseAccept(headers []string) []acceptRange {
+	var res []acceptRange
+	for _, header := range headers {
+		for entry := range strings.SplitSeq(header, ",") {
+			mediaRange, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+			typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mediaRange)), "/")
+			if !ok || typ == "" || subtype == "" || (typ == "*" && subtype != "*") {
+				continue
+			}
+			q, ok := acceptQuality(params)
+			if !ok {
+				continue
+			}
+			res = append(res, acceptRange{typ: typ, subtype: subtype, q: q})
+		}
+	}
+	return res
+}
+
+// acceptQuality extracts the q parameter from the parameters of an Accept entry, 1 if there is none.
+// It reports false for a malformed or out of range value.
+func acceptQuality(params string) (float64, bool) {
+	for param := range strings.SplitSeq(params, ";") {
+		v, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(param)), "q=")
+		if !ok {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+func encodeJSONValue(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(true)
+	return enc.Encode(v)
+}
+
+func encodeXMLValue(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// encodeCSVValue writes [][]string or a CSVMarshaler as csv records
+func encodeCSVValue(w io.Writer, v any) error {
+	var records [][]string
+	switch vv := v.(type) {
+	case [][]string:
+		records = vv
+	case CSVMarshaler:
+		recs, err := vv.MarshalCSV()
+		if err != nil {
+			return err
+		}
+		records = recs
+	default:
+		return fmt.Errorf("can't encode %T as csv, use [][]string or CSVMarshaler", v)
+	}
+	cw := csv.NewWriter(w)
+	return cw.WriteAll(records)
+}
+
+// encodeTextValue writes v formatted with %v, so strings, errors and fmt.Stringer values come out as is
+func encodeTextValue(w io.Writer, v any) error {
+	if b, ok := v.([]byte); ok {
+		_, err := w.Write(b)
+		return err
+	}
+	_, err := fmt.Fprint(w, v)
+	return err
+}
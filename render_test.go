@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type renderItem struct {
+	XMLName xml.Name `json:"-" xml:"item"`
+	ID      int      `json:"id" xml:"id"`
+	Name    string   `json:"name" xml:"name"`
+}
+
+func (i renderItem) MarshalCSV() ([][]string, error) {
+	return [][]string{{"id", "name"}, {"1", i.Name}}, nil
+}
+
+func (i renderItem) String() string { return "item " + i.Name }
+
+func TestRender(t *testing.T) {
+	tbl := []struct {
+		accept string
+		status int
+		ctype  string
+		body   string
+	}{
+		{"", 200, "application/json; charset=utf-8", `{"id":1,"name":"foo"}` + "\n"},
+		{"*/*", 200, "application/json; charset=utf-8", `{"id":1,"name":"foo"}` + "\n"},
+		{"application/json", 200, "application/json; charset=utf-8", `{"id":1,"name":"foo"}` + "\n"},
+		{"application/xml", 200, "application/xml; charset=utf-8", xml.Header + "<item><id>1</id><name>foo</name></item>"},
+		{"text/csv", 200, "text/csv; charset=utf-8", "id,name\n1,foo\n"},
+		{"text/plain", 200, "text/plain; charset=utf-8", "item foo"},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", 200, "application/xml; charset=utf-8", ""},
+		{"application/json;q=0.5, text/csv", 200, "text/csv; charset=utf-8", ""},
+		{"text/*", 200, "text/csv; charset=utf-8", ""},
+		{"text/*;q=0, text/plain", 200, "text/plain; charset=utf-8", ""},
+		{"*/*, application/json;q=0", 200, "application/xml; charset=utf-8", ""},
+		{"TEXT/PLAIN", 200, "text/plain; charset=utf-8", ""},
+		{"image/png", 406, "text/plain; charset=utf-8", ""},
+		{"application/json;q=0", 406, "text/plain; charset=utf-8", ""},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/item", http.NoBody)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			err := Render(w, req, http.StatusOK, renderItem{ID: 1, Name: "foo"})
+			if tt.status == http.StatusNotAcceptable {
+				require.ErrorIs(t, err, ErrNotAcceptable)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.ctype, w.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			if tt.body != "" {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRender_RepeatedAcceptFields(t *testing.T) {
+	req := httptest.NewRequest("GET", "/item", http.NoBody)
+	req.Header.Add("Accept", "application/json;q=0.1")
+	req.Header.Add("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	require.NoError(t, Render(w, req, http.StatusCreated, "hello"))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+}
+
+func TestRender_EncodingFailureLeavesResponseUncommitted(t *testing.T) {
+	req := httptest.NewRequest("GET", "/item", http.NoBody)
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	err := Render(w, req, http.StatusOK, JSON{"key": "val"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't encode rest.JSON as csv")
+	assert.Empty(t, w.Header().Get("Content-Type"))
+	assert.False(t, w.Flushed)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestRenderer_Register(t *testing.T) {
+	rr := NewRenderer()
+	rr.Register("application/yaml", func(w io.Writer, v any) error {
+		_, err := io.WriteString(w, "name: "+v.(renderItem).Name+"\n")
+		return err
+	})
+	rr.Register("text/plain", func(w io.Writer, _ any) error {
+		_, err := io.WriteString(w, "replaced")
+		return err
+	})
+
+	req := httptest.NewRequest("GET", "/item", http.NoBody)
+	req.Header.Set("Accept", "application/yaml")
+	w := httptest.NewRecorder()
+	require.NoError(t, rr.Render(w, req, http.StatusOK, renderItem{Name: "foo"}))
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "name: foo\n", w.Body.String())
+
+	req.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	require.NoError(t, rr.Render(w, req, http.StatusOK, renderItem{Name: "foo"}))
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "replaced", w.Body.String())
+	assert.Equal(t, []string{"application/json", "application/xml", "text/csv", "text/plain", "application/yaml"},
+		rr.mediaTypes(), "replacement keeps the position")
+
+	req.Header.Set("Accept", "image/png")
+	w = httptest.NewRecorder()
+	err := rr.Render(w, req, http.StatusOK, renderItem{Name: "foo"})
+	require.ErrorIs(t, err, ErrNotAcceptable)
+	assert.Contains(t, w.Body.String(), "application/yaml")
+}
+
+func TestRender_WriteError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/item", http.NoBody)
+	w := &failingWriter{ResponseWriter: httptest.NewRecorder(), err: errors.New("broken pipe")}
+	err := Render(w, req, http.StatusOK, "data")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken pipe")
+}
@@ -0,0 +1,357 @@
+package rest
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCacheConfig defines ResponseCache parameters.
+// Use ResponseCacheOpt functions to customize.
+type ResponseCacheConfig struct {
+	// MaxBytes bounds the size of cached responses, bodies and headers. Least recently used responses are
+	// evicted to stay in. Default: 64MB
+	MaxBytes int64
+	// DefaultTTL is the time responses without max-age or s-maxage are cached for, 0 leaves them uncached.
+	// Default: 0
+	DefaultTTL time.Duration
+}
+
+// ResponseCacheOpt is a functional option for ResponseCacheConfig
+type ResponseCacheOpt func(*ResponseCacheConfig)
+
+// ResponseCacheMaxBytes sets the size bound of cached responses
+func ResponseCacheMaxBytes(n int64) ResponseCacheOpt {
+	return func(c *ResponseCacheConfig) {
+		c.MaxBytes = n
+	}
+}
+
+// ResponseCacheDefaultTTL sets the time responses without max-age or s-maxage are cached for
+func ResponseCacheDefaultTTL(ttl time.Duration) ResponseCacheOpt {
+	return func(c *ResponseCacheConfig) {
+		c.DefaultTTL = ttl
+	}
+}
+
+// ResponseCacheStats is a snapshot of ResponseCache counters
+type ResponseCacheStats struct {
+	Hits      int64 `json:"hits"`
+	Stale     int64 `json:"stale"`
+	Misses    int64 `json:"misses"`
+	Bypasses  int64 `json:"bypasses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// ResponseCache is a server-side cache of GET responses, kept in memory. The handler decides what is cached
+// and for how long with its Cache-Control: s-maxage or max-age set the time to live, no-store, no-cache and
+// private keep a response out, and stale-while-revalidate lets an expired response be served while it is
+// refreshed in the background. Responses setting cookies or varying on "*" aren't cached, neither are
+// requests with Authorization.
+type ResponseCache struct {
+	cfg ResponseCacheConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used in front
+	entries map[string]*list.Element
+	vary    map[string]*cacheVary // by url, dropped with its last entry
+	bytes   int64
+	stats   ResponseCacheStats
+
+	flights flightGroup[cacheFlight]
+}
+
+// cacheVary is the request headers responses of the url vary on, learned from the last one
+type cacheVary struct {
+	on      []string
+	entries int // cached entries of the url
+}
+
+type cacheEntry struct {
+	key        string
+	base       string
+	resp       *capturedResponse
+	stored     time.Time
+	freshUntil time.Time
+	staleUntil time.Time
+}
+
+type cacheFlight struct {
+	resp      *capturedResponse
+	cacheable bool
+	key       string // the key of requests the response is good for, by its Vary
+}
+
+// NewResponseCache makes an empty cache with the given options
+func NewResponseCache(opts ...ResponseCacheOpt) *ResponseCache {
+	cfg := ResponseCacheConfig{MaxBytes: 64 << 20}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &ResponseCache{
+		cfg:     cfg,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		vary:    make(map[string]*cacheVary),
+	}
+}
+
+// Handler is the caching middleware. Responses are marked with X-Cache: HIT, STALE, MISS or BYPASS.
+// Concurrent misses of the same response run the handler once, and so do background refreshes.
+func (c *ResponseCache) Handler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Authorization") != "" {
+			c.count(func(s *ResponseCacheStats) { s.Bypasses++ })
+			w.Header().Set("X-Cache", "BYPASS")
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.key(r)
+		now := c.now()
+		c.mu.Lock()
+		var entry *cacheEntry
+		if el, ok := c.entries[key]; ok {
+			entry = el.Value.(*cacheEntry)
+			if now.After(entry.staleUntil) {
+				c.removeLocked(el)
+				entry = nil
+			} else {
+				c.lru.MoveToFront(el)
+			}
+		}
+		c.mu.Unlock()
+
+		switch {
+		case entry != nil && !now.After(entry.freshUntil):
+			c.count(func(s *ResponseCacheStats) { s.Hits++ })
+			c.serve(w, r, entry, "HIT", now)
+			return
+		case entry != nil:
+			c.count(func(s *ResponseCacheStats) { s.Stale++ })
+			c.serve(w, r, entry, "STALE", now)
+			rr := r.Clone(context.WithoutCancel(r.Context()))
+			go func() {
+				// no one to pass a panic of the refresh to, it's dropped and the stale entry kept
+				defer func() { _ = recover() }()
+				c.flights.do(key, func() cacheFlight { return c.fetch(h, rr) })
+			}()
+			return
+		}
+
+		c.count(func(s *ResponseCacheStats) { s.Misses++ })
+		w.Header().Set("X-Cache", "MISS")
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r) // a HEAD response has no body to cache for GET
+			return
+		}
+		res, shared, ok := c.flights.do(key, func() cacheFlight { return c.fetch(h, r) })
+		// the key of the flight knows Vary only if learned from an earlier response, so a waiter
+		// checks the response varies in nothing the waiter's request differs in
+		if shared && (!ok || !res.cacheable || res.key != cacheVaryKey(r, cacheBaseKey(r), responseVary(res.resp.header))) {
+			h.ServeHTTP(w, r) // not to be shared, it was made for another request
+			return
+		}
+		res.resp.replay(w)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Stats returns a snapshot of cache counters
+func (c *ResponseCache) Stats() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.stats
+	res.Entries, res.Bytes = len(c.entries), c.bytes
+	return res
+}
+
+// fetch runs the handler and stores its response if it is cacheable
+func (c *ResponseCache) fetch(h http.Handler, r *http.Request) cacheFlight {
+	cw := newCaptureWriter()
+	h.ServeHTTP(cw, r)
+	resp := cw.response()
+
+	now := c.now()
+	fresh, stale, ok := c.lifetime(resp)
+	if !ok {
+		return cacheFlight{resp: resp}
+	}
+	varyOn := responseVary(resp.header)
+	if slices.Contains(varyOn, "*") {
+		return cacheFlight{resp: resp}
+	}
+
+	base := cacheBaseKey(r)
+	entry := &cacheEntry{key: cacheVaryKey(r, base, varyOn), base: base, resp: resp, stored: now,
+		freshUntil: now.Add(fresh), staleUntil: now.Add(fresh + stale)}
+	size := resp.size() + int64(len(entry.key))
+	if size > c.cfg.MaxBytes {
+		return cacheFlight{resp: resp, cacheable: true, key: entry.key}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.entries[entry.key]; found {
+		c.removeLocked(el)
+	}
+	v, found := c.vary[base]
+	if !found {
+		v = &cacheVary{}
+		c.vary[base] = v
+	}
+	v.on = varyOn
+	v.entries++
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += size
+	for c.bytes > c.cfg.MaxBytes {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return cacheFlight{resp: resp, cacheable: true, key: entry.key}
+}
+
+// lifetime returns how long the response is fresh and then may be served stale, ok is unset for responses
+// not to be cached at all
+func (c *ResponseCache) lifetime(resp *capturedResponse) (fresh, stale time.Duration, ok bool) {
+	if !cacheableStatus(resp.status) {
+		return 0, 0, false
+	}
+	if len(resp.header.Values("Set-Cookie")) > 0 {
+		return 0, 0, false
+	}
+
+	cc := parseCacheControl(resp.header.Values("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, found := cc[d]; found {
+			return 0, 0, false
+		}
+	}
+	fresh = c.cfg.DefaultTTL
+	if v, found := cc["max-age"]; found {
+		fresh = cacheSeconds(v)
+	}
+	if v, found := cc["s-maxage"]; found {
+		fresh = cacheSeconds(v)
+	}
+	if v, found := cc["stale-while-revalidate"]; found {
+		stale = cacheSeconds(v)
+	}
+	return fresh, stale, fresh > 0
+}
+
+func (c *ResponseCache) serve(w http.ResponseWriter, r *http.Request, entry *cacheEntry, state string, now time.Time) {
+	w.Header().Set("X-Cache", state)
+	w.Header().Set("Age", strconv.Itoa(int(now.Sub(entry.stored).Seconds())))
+	if r.Method == http.MethodHead {
+		h := w.Header()
+		for k, v := range entry.resp.header {
+			h[k] = append([]string(nil), v...)
+		}
+		w.WriteHeader(entry.resp.status)
+		return
+	}
+	entry.resp.replay(w)
+}
+
+func (c *ResponseCache) key(r *http.Request) string {
+	base := cacheBaseKey(r)
+	c.mu.Lock()
+	var varyOn []string
+	if v, found := c.vary[base]; found {
+		varyOn = v.on
+	}
+	c.mu.Unlock()
+	return cacheVaryKey(r, base, varyOn)
+}
+
+func (c *ResponseCache) removeLocked(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.resp.size() + int64(len(entry.key))
+	if v, found := c.vary[entry.base]; found {
+		if v.entries--; v.entries <= 0 {
+			delete(c.vary, entry.base)
+		}
+	}
+}
+
+func (c *ResponseCache) count(fn func(s *ResponseCacheStats)) {
+	c.mu.Lock()
+	fn(&c.stats)
+	c.mu.Unlock()
+}
+
+// cacheableStatus reports whether responses with the status can be cached, and so shared
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+func cacheBaseKey(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.Host + r.URL.EscapedPath()
+	}
+	return r.Host + r.URL.EscapedPath() + "?" + r.URL.RawQuery
+}
+
+// cacheVaryKey adds values of the request headers the response varies on to the key
+func cacheVaryKey(r *http.Request, base string, varyOn []string) string {
+	var bld strings.Builder
+	bld.WriteString(base)
+	for _, name := range varyOn {
+		bld.WriteString("\n" + name + ":" + strings.Join(r.Header.Values(name), ","))
+	}
+	return bld.String()
+}
+
+// responseVary returns the header names of Vary, canonical and sorted, so the order they are listed in
+// makes no difference
+func responseVary(h http.Header) []string {
+	var res []string
+	for _, v := range h.Values("Vary") {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				res = append(res, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// parseCacheControl splits Cache-Control fields into lowercased directives and their unquoted values
+func parseCacheControl(values []string) map[string]string {
+	res := map[string]string{}
+	for _, v := range values {
+		for d := range strings.SplitSeq(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name == "" {
+				continue
+			}
+			res[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return res
+}
+
+func cacheSeconds(v string) time.Duration {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,273 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/shared":
+			w.Header().Set("Cache-Control", "max-age=0, s-maxage=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/cookie":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", "a=b")
+		case "/error":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			_, _ = fmt.Fprintf(w, "%s-", r.Header.Get("Accept-Language"))
+		}
+		_, _ = fmt.Fprintf(w, "%d", n)
+	})
+	c := NewResponseCache()
+	h := c.Handler(handler)
+
+	get := func(method, url string, hdrs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, http.NoBody)
+		for i := 0; i < len(hdrs); i += 2 {
+			req.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("GET", "/public")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "1", w.Body.String())
+	w = get("GET", "/public")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, "0", w.Header().Get("Age"))
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+	w = get("HEAD", "/public")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, "1", get("GET", "/public?").Body.String(), "empty query is the same url")
+
+	assert.Equal(t, "MISS", get("GET", "/shared").Header().Get("X-Cache"))
+	assert.Equal(t, "HIT", get("GET", "/shared").Header().Get("X-Cache"), "s-maxage wins over max-age")
+
+	for _, path := range []string{"/private", "/nostore", "/cookie", "/error"} {
+		first, second := get("GET", path), get("GET", path)
+		assert.Equal(t, "MISS", second.Header().Get("X-Cache"), path)
+		assert.NotEqual(t, first.Body.String(), second.Body.String(), path)
+	}
+
+	assert.Equal(t, "BYPASS", get("POST", "/public").Header().Get("X-Cache"))
+	assert.Equal(t, "BYPASS", get("GET", "/public", "Authorization", "Bearer x").Header().Get("X-Cache"))
+
+	en := get("GET", "/vary", "Accept-Language", "en").Body.String()
+	de := get("GET", "/vary", "Accept-Language", "de").Body.String()
+	assert.True(t, strings.HasPrefix(en, "en-"))
+	assert.True(t, strings.HasPrefix(de, "de-"))
+	assert.Equal(t, en, get("GET", "/vary", "Accept-Language", "en").Body.String())
+	assert.Equal(t, de, get("GET", "/vary", "Accept-Language", "de").Body.String())
+
+	stats := c.Stats()
+	assert.Equal(t, int64(6), stats.Hits)
+	assert.Equal(t, int64(2), stats.Bypasses)
+	assert.Equal(t, 4, stats.Entries)
+	assert.Positive(t, stats.Bytes)
+}
+
+func TestResponseCache_StaleWhileRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	refreshed := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=20")
+		_, _ = fmt.Fprintf(w, "%d", n)
+		if n > 1 {
+			refreshed <- struct{}{}
+		}
+	})
+	c := NewResponseCache()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	c.now = func() time.Time { mu.Lock(); defer mu.Unlock(); return now }
+	advance := func(d time.Duration) { mu.Lock(); now = now.Add(d); mu.Unlock() }
+	h := c.Handler(handler)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/data", http.NoBody))
+		return w
+	}
+
+	assert.Equal(t, "1", get().Body.String())
+	advance(15 * time.Second)
+	w := get()
+	assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+	assert.Equal(t, "1", w.Body.String(), "stale response served right away")
+	assert.Equal(t, "15", w.Header().Get("Age"))
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("response is not refreshed")
+	}
+	require.Eventually(t, func() bool { return get().Body.String() == "2" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "HIT", get().Header().Get("X-Cache"))
+
+	advance(31 * time.Second)
+	w = get()
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"), "too stale to serve")
+	assert.Equal(t, "3", w.Body.String())
+}
+
+func TestResponseCache_RefreshPanics(t *testing.T) {
+	var calls atomic.Int32
+	refreshed := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			select {
+			case refreshed <- struct{}{}:
+			default:
+			}
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		_, _ = w.Write([]byte("data"))
+	})
+	c := NewResponseCache()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	c.now = func() time.Time { mu.Lock(); defer mu.Unlock(); return now }
+	h := c.Handler(handler)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/data", http.NoBody))
+		return w
+	}
+
+	assert.Equal(t, "data", get().Body.String())
+	mu.Lock()
+	now = now.Add(5 * time.Second)
+	mu.Unlock()
+	assert.Equal(t, "STALE", get().Header().Get("X-Cache"))
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("response is not refreshed")
+	}
+	require.Eventually(t, func() bool { return get().Body.String() == "data" && calls.Load() >= 3 }, time.Second,
+		10*time.Millisecond, "stale entry kept after the failed refresh, and refreshed again")
+}
+
+func TestResponseCache_SingleflightMiss(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("data"))
+	})
+	h := NewResponseCache().Handler(handler)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/hot", http.NoBody))
+			assert.Equal(t, "data", w.Body.String())
+		}()
+	}
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond) // let the rest join the flight
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestResponseCache_SingleflightMissVary(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte("lang=" + r.Header.Get("Accept-Language")))
+	})
+	h := NewResponseCache().Handler(handler)
+
+	var wg sync.WaitGroup
+	get := func(lang string) {
+		defer wg.Done()
+		req := httptest.NewRequest("GET", "/hot", http.NoBody)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, "lang="+lang, w.Body.String())
+	}
+	wg.Add(1)
+	go get("en") // the leader, nothing learned about Vary yet
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		wg.Add(1)
+		go get(lang)
+	}
+	time.Sleep(50 * time.Millisecond) // let the rest join the flight
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(3), calls.Load(), "en shared, each fr made its own")
+}
+
+func TestResponseCache_EvictsByBytes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(strings.Repeat("x", 400)))
+	})
+	c := NewResponseCache(ResponseCacheMaxBytes(1000))
+	h := c.Handler(handler)
+	get := func(url string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, http.NoBody))
+		return w.Header().Get("X-Cache")
+	}
+
+	get("/a")
+	get("/b")
+	assert.Equal(t, "HIT", get("/a")) // makes /b the least recently used
+	get("/c")
+	stats := c.Stats()
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.LessOrEqual(t, stats.Bytes, int64(1000))
+	assert.Equal(t, "HIT", get("/a"))
+	assert.Equal(t, "HIT", get("/c"))
+	assert.Equal(t, "MISS", get("/b"))
+
+	for i := range 100 {
+		get("/a?unique=" + strconv.Itoa(i))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Len(t, c.vary, len(c.entries), "vary of evicted urls dropped")
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/go-pkgz/rest/realip"
 )
 
 // SecureConfig defines security headers configuration.
@@ -186,21 +188,16 @@ func isHTTPS(r *http.Request) bool {
 	return false
 }
 
-// forwardedProtoIsHTTPS parses RFC 7239 Forwarded header to check for proto=https.
+// forwardedProtoIsHTTPS parses RFC 7239 Forwarded header to check for proto=https in any of its elements.
 // The header format is: Forwarded: for=1.2.3.4;proto=https;by=proxy, for=5.6.7.8
-// Parameters are separated by semicolons, multiple forwarded elements by commas.
 func forwardedProtoIsHTTPS(header string) bool {
-	// split by comma to get individual forwarded elements
-	for element := range strings.SplitSeq(header, ",") {
-		// split by semicolon to get parameters within element
-		for param := range strings.SplitSeq(element, ";") {
-			param = strings.TrimSpace(param)
-			// check for proto=https (case-insensitive per RFC 7239)
-			if len(param) > 6 && strings.EqualFold(param[:6], "proto=") {
-				if strings.EqualFold(strings.TrimSpace(param[6:]), "https") {
-					return true
-				}
-			}
+	hops, err := realip.ParseForwarded([]string{header})
+	if err != nil {
+		return false
+	}
+	for _, hop := range hops {
+		if hop.Proto == "https" {
+			return true
 		}
 	}
 	return false
@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureScheme describes how a webhook sender signs requests with HMAC. The zero values of optional
+// fields make the hex HMAC-SHA256 of the body, sent as the whole value of Header.
+type SignatureScheme struct {
+	// Header is the name of the header with the signature
+	Header string
+	// Prefix is cut from the signature, like "sha256=". Optional
+	Prefix string
+	// TimestampHeader is the name of the header with the unix time the request was signed at. Optional,
+	// schemes without a timestamp can't be checked for replays
+	TimestampHeader string
+	// Parse extracts the signatures and the timestamp from the value of Header, for schemes putting both
+	// into it, like "t=1700000000,v1=hex". Optional, replaces Prefix and TimestampHeader. The timestamp is
+	// checked if returned; a scheme requiring one has to return no signatures without it
+	Parse func(value string) (sigs []string, ts string)
+	// Payload makes the signed message from the timestamp and the body. Optional, the body is signed as is
+	Payload func(ts string, body []byte) []byte
+	// Base64 makes signatures base64 encoded instead of hex
+	Base64 bool
+	// Hash is the hash of HMAC. Default: sha256.New
+	Hash func() hash.Hash
+}
+
+// Signature schemes of known webhook senders
+var (
+	// GitHubSignature is "X-Hub-Signature-256: sha256=<hex hmac of the body>"
+	GitHubSignature = SignatureScheme{Header: "X-Hub-Signature-256", Prefix: "sha256="}
+
+	// StripeSignature is "Stripe-Signature: t=<ts>,v1=<hex hmac of "ts.body">", with v1 repeated during
+	// secret rotation. The timestamp is required
+	StripeSignature = SignatureScheme{
+		Header: "Stripe-Signature",
+		Parse: func(value string) (sigs []string, ts string) {
+			for item := range strings.SplitSeq(value, ",") {
+				k, v, _ := strings.Cut(strings.TrimSpace(item), "=")
+				switch k {
+				case "t":
+					ts = v
+				case "v1":
+					sigs = append(sigs, v)
+				}
+			}
+			if ts == "" {
+				return nil, ""
+			}
+			return sigs, ts
+		},
+		Payload: func(ts string, body []byte) []byte { return append([]byte(ts+"."), body...) },
+	}
+
+	// SlackSignature is "X-Slack-Signature: v0=<hex hmac of "v0:ts:body">" with the timestamp
+	// in X-Slack-Request-Timestamp
+	SlackSignature = SignatureScheme{
+		Header:          "X-Slack-Signature",
+		Prefix:          "v0=",
+		TimestampHeader: "X-Slack-Request-Timestamp",
+		Payload:         func(ts string, body []byte) []byte { return append([]byte("v0:"+ts+":"), body...) },
+	}
+)
+
+// SignatureConfig defines VerifySignature middleware parameters.
+// Use SignatureOpt functions to customize.
+type SignatureConfig struct {
+	// Window is how far the signed timestamp can be from now, in either direction, to keep signed requests
+	// from being replayed later. Checked for schemes with a timestamp only. Default: 5m
+	Window time.Duration
+	// MaxBody is the size limit of the body read into memory to check the signature. Default: 1MB
+	MaxBody int64
+}
+
+// SignatureOpt is a functional option for SignatureConfig
+type SignatureOpt func(*SignatureConfig)
+
+// SignatureWindow sets how far the signed timestamp can be from now
+func SignatureWindow(d time.Duration) SignatureOpt {
+	return func(c *SignatureConfig) {
+		c.Window = d
+	}
+}
+
+// SignatureMaxBody sets the size limit of the body
+func SignatureMaxBody(size int64) SignatureOpt {
+	return func(c *SignatureConfig) {
+		c.MaxBody = size
+	}
+}
+
+// VerifySignature middleware checks the HMAC signature of webhook requests made by the scheme, like
+// GitHubSignature, with any of the secrets. More than one secret keeps both old and new ones working while
+// a secret is rotated. The body is read into memory to check it and restored for the handler.
+// A request without a signature, with a wrong one or with a timestamp out of the window gets
+// StatusUnauthorized (401), a body over the limit gets StatusRequestEntityTooLarge (413).
+// It panics with no secrets or an empty one, as HMAC with an empty key can be made by anyone.
+func VerifySignature(scheme SignatureScheme, secrets []string, opts ...SignatureOpt) func(http.Handler) http.Handler {
+	if len(secrets) == 0 || slices.Contains(secrets, "") {
+		panic("rest: VerifySignature needs non-empty secrets")
+	}
+	cfg := SignatureConfig{Window: 5 * time.Minute, MaxBody: 1024 * 1024}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if scheme.Hash == nil {
+		scheme.Hash = sha256.New
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			sigs, ts := scheme.signatures(r)
+			if len(sigs) == 0 {
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "signature required"})
+				return
+			}
+			if ts != "" || (scheme.Parse == nil && scheme.TimestampHeader != "") {
+				if !signatureTimeValid(ts, time.Now(), cfg.Window) {
+					_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "signature expired"})
+					return
+				}
+			}
+
+			if r.ContentLength > cfg.MaxBody {
+				_ = EncodeJSON(w, http.StatusRequestEntityTooLarge, JSON{"error": "body too large"})
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, cfg.MaxBody+1))
+			if err != nil {
+				_ = EncodeJSON(w, http.StatusBadRequest, JSON{"error": "can't read body"})
+				return
+			}
+			_ = r.Body.Close() // the original body already consumed
+			if int64(len(body)) > cfg.MaxBody {
+				_ = EncodeJSON(w, http.StatusRequestEntityTooLarge, JSON{"error": "body too large"})
+				return
+			}
+
+			if !scheme.verify(secrets, sigs, ts, body) {
+				_ = EncodeJSON(w, http.StatusUnauthorized, JSON{"error": "invalid signature"})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// signatures returns the signatures of the request, undecoded, and the signed timestamp
+func (s SignatureScheme) signatures(r *http.Request) (sigs []string, ts string) {
+	value := r.Header.Get(s.Header)
+	if value == "" {
+		return nil, ""
+	}
+	if s.Parse != nil {
+		return s.Parse(value)
+	}
+	if s.TimestampHeader != "" {
+		ts = r.Header.Get(s.TimestampHeader)
+	}
+	sig, ok := strings.CutPrefix(value, s.Prefix)
+	if !ok {
+		return nil, ts
+	}
+	return []string{sig}, ts
+}
+
+// verify reports whether any of the signatures matches the payload signed with any of the secrets
+func (s SignatureScheme) verify(secrets, sigs []string, ts string, body []byte) bool {
+	payload := body
+	if s.Payload != nil {
+		payload = s.Payload(ts, body)
+	}
+	decode := hex.DecodeString
+	if s.Base64 {
+		decode = base64.StdEncoding.DecodeString
+	}
+
+	matched := false
+	for _, secret := range secrets {
+		mac := hmac.New(s.Hash, []byte(secret))
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, sig := range sigs {
+			got, err := decode(sig)
+			if err != nil {
+				continue
+			}
+			// all pairs are checked, so the time doesn't tell which secret matched
+			if hmac.Equal(expected, got) {
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// signatureTimeValid reports whether the unix timestamp is within the window from now
+func signatureTimeValid(ts string, now time.Time, window time.Duration) bool {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := now.Sub(time.Unix(sec, 0))
+	return diff <= window && diff >= -window
+}
@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // test of a custom hash
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	const body = `{"action":"opened"}`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	tbl := []struct {
+		name    string
+		scheme  SignatureScheme
+		headers map[string]string
+		status  int
+	}{
+		{"github", GitHubSignature, map[string]string{"X-Hub-Signature-256": "sha256=" + testHMAC("new", body)}, http.StatusOK},
+		{"github old secret", GitHubSignature, map[string]string{"X-Hub-Signature-256": "sha256=" + testHMAC("old", body)}, http.StatusOK},
+		{"github wrong secret", GitHubSignature, map[string]string{"X-Hub-Signature-256": "sha256=" + testHMAC("bad", body)},
+			http.StatusUnauthorized},
+		{"github no prefix", GitHubSignature, map[string]string{"X-Hub-Signature-256": testHMAC("new", body)}, http.StatusUnauthorized},
+		{"github not hex", GitHubSignature, map[string]string{"X-Hub-Signature-256": "sha256=zz"}, http.StatusUnauthorized},
+		{"github no header", GitHubSignature, nil, http.StatusUnauthorized},
+
+		{"stripe", StripeSignature, map[string]string{"Stripe-Signature": "t=" + ts + ",v1=" + testHMAC("new", ts+"."+body)}, http.StatusOK},
+		{"stripe rotated", StripeSignature, map[string]string{
+			"Stripe-Signature": "t=" + ts + ", v1=" + testHMAC("other", ts+"."+body) + ", v1=" + testHMAC("old", ts+"."+body) + ",v0=x"},
+			http.StatusOK},
+		{"stripe v0 only", StripeSignature, map[string]string{"Stripe-Signature": "t=" + ts + ",v0=" + testHMAC("new", ts+"."+body)},
+			http.StatusUnauthorized},
+		{"stripe replayed", StripeSignature, map[string]string{"Stripe-Signature": "t=" + old + ",v1=" + testHMAC("new", old+"."+body)},
+			http.StatusUnauthorized},
+		{"stripe changed timestamp", StripeSignature, map[string]string{"Stripe-Signature": "t=" + ts + ",v1=" + testHMAC("new", old+"."+body)},
+			http.StatusUnauthorized},
+		{"stripe no timestamp", StripeSignature, map[string]string{"Stripe-Signature": "v1=" + testHMAC("new", "."+body)},
+			http.StatusUnauthorized},
+
+		{"slack", SlackSignature, map[string]string{"X-Slack-Signature": "v0=" + testHMAC("new", "v0:"+ts+":"+body),
+			"X-Slack-Request-Timestamp": ts}, http.StatusOK},
+		{"slack replayed", SlackSignature, map[string]string{"X-Slack-Signature": "v0=" + testHMAC("new", "v0:"+old+":"+body),
+			"X-Slack-Request-Timestamp": old}, http.StatusUnauthorized},
+		{"slack no timestamp", SlackSignature, map[string]string{"X-Slack-Signature": "v0=" + testHMAC("new", "v0::"+body)},
+			http.StatusUnauthorized},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			h := VerifySignature(tt.scheme, []string{"new", "old"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.Equal(t, body, string(b), "body restored")
+				_, _ = w.Write([]byte("ok"))
+			}))
+			req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestVerifySignature_Custom(t *testing.T) {
+	const body = "payload"
+	scheme := SignatureScheme{Header: "X-Signature", TimestampHeader: "X-Timestamp", Base64: true, Hash: sha1.New,
+		Payload: func(ts string, body []byte) []byte { return []byte(ts + "\n" + string(body)) }}
+	h := VerifySignature(scheme, []string{"secret"}, SignatureWindow(time.Minute))(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	sign := func(ts string) string {
+		mac := hmac.New(sha1.New, []byte("secret"))
+		mac.Write([]byte(ts + "\n" + body))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	call := func(ts string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("X-Signature", sign(ts))
+		req.Header.Set("X-Timestamp", ts)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	now := time.Now()
+	assert.Equal(t, http.StatusOK, call(strconv.FormatInt(now.Unix(), 10)))
+	assert.Equal(t, http.StatusOK, call(strconv.FormatInt(now.Add(50*time.Second).Unix(), 10)), "sender clock ahead")
+	assert.Equal(t, http.StatusUnauthorized, call(strconv.FormatInt(now.Add(-2*time.Minute).Unix(), 10)))
+	assert.Equal(t, http.StatusUnauthorized, call(strconv.FormatInt(now.Add(2*time.Minute).Unix(), 10)))
+	assert.Equal(t, http.StatusUnauthorized, call("yesterday"))
+}
+
+func TestVerifySignature_ParseNoTimestamp(t *testing.T) {
+	const body = "payload"
+	scheme := SignatureScheme{Header: "X-Signature", Parse: func(value string) (sigs []string, ts string) {
+		return []string{value}, ""
+	}}
+	h := VerifySignature(scheme, []string{"secret"})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	call := func(sig string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("X-Signature", sig)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, call(testHMAC("secret", body)), "no timestamp, no window to check")
+	assert.Equal(t, http.StatusUnauthorized, call(testHMAC("bad", body)))
+}
+
+func TestVerifySignature_NoSecrets(t *testing.T) {
+	assert.Panics(t, func() { VerifySignature(GitHubSignature, nil) })
+	assert.Panics(t, func() { VerifySignature(GitHubSignature, []string{}) })
+	assert.Panics(t, func() { VerifySignature(GitHubSignature, []string{"secret", ""}) }, "empty secret from unset env")
+}
+
+func TestVerifySignature_MaxBody(t *testing.T) {
+	h := VerifySignature(GitHubSignature, []string{"secret"}, SignatureMaxBody(10))(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	call := func(body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		req.Header.Set("X-Hub-Signature-256", "sha256="+testHMAC("secret", body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, call("0123456789", false).Code)
+	w := call("0123456789a", false)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"error":"body too large"}`, w.Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, call("0123456789a", true).Code)
+}
@@ -3,7 +3,11 @@ package rest
 import (
 	"bytes"
 	"io"
+	"mime"
 	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
 )
 
 // SizeLimit middleware checks if body size is above the limit and returns StatusRequestEntityTooLarge (413)
@@ -38,3 +42,165 @@ func SizeLimit(size int64) func(http.Handler) http.Handler {
 		return http.HandlerFunc(fn)
 	}
 }
+
+// SizeLimitRule sets the body size limit of requests matching Pattern. A negative Size means no limit.
+type SizeLimitRule struct {
+	Pattern string
+	Size    int64
+}
+
+// SizeLimitConfig defines per-route and per-content-type limits of SizeLimitStream.
+// Use SizeLimitOpt functions to customize.
+type SizeLimitConfig struct {
+	// Paths are matched against the url path with path.Match, the first match wins and takes
+	// precedence over ContentTypes
+	Paths []SizeLimitRule
+	// ContentTypes are matched against the request's media type, "type/*" matches all subtypes.
+	// The first match wins.
+	ContentTypes []SizeLimitRule
+}
+
+// SizeLimitOpt is a functional option for SizeLimitConfig
+type SizeLimitOpt func(*SizeLimitConfig)
+
+// SizeLimitPath sets the limit of requests with url path matching the path.Match pattern
+func SizeLimitPath(pattern string, size int64) SizeLimitOpt {
+	return func(c *SizeLimitConfig) {
+		c.Paths = append(c.Paths, SizeLimitRule{Pattern: pattern, Size: size})
+	}
+}
+
+// SizeLimitContentType sets the limit of requests with the media type, "type/*" matches all subtypes
+func SizeLimitContentType(mediaType string, size int64) SizeLimitOpt {
+	return func(c *SizeLimitConfig) {
+		c.ContentTypes = append(c.ContentTypes, SizeLimitRule{Pattern: strings.ToLower(mediaType), Size: size})
+	}
+}
+
+// SizeLimitStream middleware limits body size without buffering it. Unlike SizeLimit, the body is passed on
+// as is and reading past the limit fails with *http.MaxBytesError. Whatever the handler responds after that,
+// the client gets StatusRequestEntityTooLarge (413) with {"error": "request body too large"}, and so does
+// a request with Content-Length over the limit, without reaching the handler. The default size applies to
+// requests matching no rule, a negative size means no limit.
+func SizeLimitStream(size int64, opts ...SizeLimitOpt) func(http.Handler) http.Handler {
+	cfg := SizeLimitConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			limit := cfg.limit(r, size)
+			if limit < 0 || r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				renderJSONWithStatus(w, JSON{"error": "request body too large"}, http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			body := &sizeLimitBody{ReadCloser: r.Body, limit: limit}
+			sw := &sizeLimitWriter{ResponseWriter: w, body: body}
+			r.Body = body
+			h.ServeHTTP(sw, r)
+			if !sw.wroteHeader && body.exceeded.Load() {
+				sw.reject()
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func (c SizeLimitConfig) limit(r *http.Request, size int64) int64 {
+	for _, rule := range c.Paths {
+		if ok, err := path.Match(rule.Pattern, r.URL.Path); err == nil && ok {
+			return rule.Size
+		}
+	}
+	if len(c.ContentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return size
+		}
+		for _, rule := range c.ContentTypes {
+			prefix, wildcard := strings.CutSuffix(rule.Pattern, "/*")
+			if rule.Pattern == mediaType || (wildcard && strings.HasPrefix(mediaType, prefix+"/")) {
+				return rule.Size
+			}
+		}
+	}
+	return size
+}
+
+// sizeLimitBody fails reads past the limit the way http.MaxBytesReader does and records the overflow
+type sizeLimitBody struct {
+	io.ReadCloser
+	limit    int64
+	n        int64
+	exceeded atomic.Bool
+}
+
+func (b *sizeLimitBody) Read(p []byte) (int, error) {
+	if b.exceeded.Load() {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	if int64(len(p)) > b.limit-b.n+1 {
+		p = p[:b.limit-b.n+1] // one byte past the limit is enough to tell it is crossed
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.n > b.limit {
+		b.exceeded.Store(true)
+		return n - int(b.n-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
+
+// sizeLimitWriter replaces the handler's response with 413 once the body went over the limit
+type sizeLimitWriter struct {
+	http.ResponseWriter
+	body        *sizeLimitBody
+	wroteHeader bool
+	rejected    bool
+}
+
+func (w *sizeLimitWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code) // informational, like 103, the final status is still to come
+		return
+	}
+	w.wroteHeader = true
+	if w.body.exceeded.Load() {
+		w.reject()
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sizeLimitWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.rejected {
+		return len(p), nil // the handler's response is dropped
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the original writer, for http.ResponseController
+func (w *sizeLimitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *sizeLimitWriter) reject() {
+	w.wroteHeader, w.rejected = true, true
+	h := w.ResponseWriter.Header()
+	for _, k := range []string{"Content-Length", "Content-Encoding", "Etag", "Last-Modified"} {
+		h.Del(k)
+	}
+	renderJSONWithStatus(w.ResponseWriter, JSON{"error": "request body too large"}, http.StatusRequestEntityTooLarge)
+}
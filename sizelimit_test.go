@@ -64,3 +64,99 @@ func TestSizeLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestSizeLimitStream(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var mbe *http.MaxBytesError
+			assert.ErrorAs(t, err, &mbe)
+			http.Error(w, "can't read body", http.StatusBadRequest) // replaced by 413
+			return
+		}
+		_, err = w.Write(body)
+		require.NoError(t, err)
+	})
+
+	mw := SizeLimitStream(10, SizeLimitPath("/upload/*", 100), SizeLimitPath("/free", -1),
+		SizeLimitContentType("image/*", 50), SizeLimitContentType("application/json", 5))
+	ts := httptest.NewServer(mw(handler))
+	defer ts.Close()
+
+	tbl := []struct {
+		path        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{"/", "text/plain", "1234567890", 200},
+		{"/", "text/plain", "12345678901", 413},
+		{"/", "application/json; charset=utf-8", "123456", 413},
+		{"/", "image/png", strings.Repeat("x", 50), 200},
+		{"/", "image/png", strings.Repeat("x", 51), 413},
+		{"/upload/img", "image/png", strings.Repeat("x", 100), 200},
+		{"/upload/img", "image/png", strings.Repeat("x", 101), 413},
+		{"/free", "text/plain", strings.Repeat("x", 1000), 200},
+		{"/", "", "", 200},
+	}
+
+	for i, tt := range tbl {
+		for _, wrap := range []bool{false, true} {
+			t.Run(fmt.Sprintf("test-%d/%v", i, wrap), func(t *testing.T) {
+				var reader io.Reader = strings.NewReader(tt.body)
+				if wrap {
+					reader = io.NopCloser(reader) // to prevent ContentLength setting up
+				}
+				req, err := http.NewRequest("POST", ts.URL+tt.path, reader)
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", tt.contentType)
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.code, resp.StatusCode)
+				if tt.code == http.StatusRequestEntityTooLarge {
+					assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+					assert.Equal(t, `{"error":"request body too large"}`+"\n", string(body))
+					return
+				}
+				assert.Equal(t, tt.body, string(body))
+			})
+		}
+	}
+}
+
+func TestSizeLimitStream_HandlerIgnoresError(t *testing.T) {
+	// handler reads past the limit and responds nothing, the client still gets 413
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	})
+	req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader("12345678901")))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	SizeLimitStream(10)(handler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, `{"error":"request body too large"}`+"\n", w.Body.String())
+}
+
+func TestSizeLimitStream_Informational(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	ts := httptest.NewServer(SizeLimitStream(10)(handler))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		body string
+		code int
+	}{{"1234567890", http.StatusNotFound}, {"12345678901", http.StatusRequestEntityTooLarge}} {
+		resp, err := http.Post(ts.URL, "text/plain", io.NopCloser(strings.NewReader(tt.body)))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, tt.code, resp.StatusCode, "final status after 103")
+	}
+}
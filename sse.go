@@ -0,0 +1,341 @@
+package rest
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEEvent is a single server-sent event. Data may span several lines, each one is sent as its own
+// data field. Empty fields are left out, Retry is sent in milliseconds.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// SSEWriter writes server-sent events (text/event-stream) to a response, flushing every event through
+// http.ResponseController, so it works behind Gzip and logger middlewares. It is safe for concurrent use.
+type SSEWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	lastEventID string
+}
+
+// sseFieldBreaks removes line breaks from single-line fields, where they would end the field early
+var sseFieldBreaks = strings.NewReplacer("\r\n", "", "\r", "", "\n", "", "\x00", "")
+
+// NewSSEWriter sets event stream headers and sends them to the client. It fails when the response can't
+// be flushed, as events stuck in a buffer are of no use, which is the case under the Timeout middleware.
+func NewSSEWriter(w http.ResponseWriter, r *http.Request) (*SSEWriter, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	res := &SSEWriter{w: w, rc: http.NewResponseController(w), lastEventID: r.Header.Get("Last-Event-ID")}
+	if err := res.rc.Flush(); err != nil {
+		return nil, fmt.Errorf("can't stream events: %w", err)
+	}
+	return res, nil
+}
+
+// LastEventID returns the id of the last event the client saw before reconnecting, empty on a first connect
+func (s *SSEWriter) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes the event and flushes it to the client
+func (s *SSEWriter) Send(ev SSEEvent) error {
+	var bld strings.Builder
+	if ev.ID != "" {
+		bld.WriteString("id: " + sseFieldBreaks.Replace(ev.ID) + "\n")
+	}
+	if ev.Event != "" {
+		bld.WriteString("event: " + sseFieldBreaks.Replace(ev.Event) + "\n")
+	}
+	if ev.Retry > 0 {
+		bld.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	if ev.Data != "" {
+		data := strings.ReplaceAll(strings.ReplaceAll(ev.Data, "\r\n", "\n"), "\r", "\n")
+		for line := range strings.SplitSeq(data, "\n") {
+			bld.WriteString("data: " + line + "\n")
+		}
+	}
+	bld.WriteString("\n")
+	return s.write(bld.String())
+}
+
+// Heartbeat sends a comment line. Clients ignore it, but it keeps proxies from closing an idle stream
+// and reveals a gone client through the write error.
+func (s *SSEWriter) Heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *SSEWriter) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+	if err := s.rc.Flush(); err != nil {
+		return fmt.Errorf("flush event: %w", err)
+	}
+	return nil
+}
+
+// SSEConfig defines SSEBroker parameters.
+// Use SSEOpt functions to customize.
+type SSEConfig struct {
+	// BufferSize is the number of events queued per client. A client falling this far behind is
+	// evicted, its connection closed, so it can reconnect and catch up from history. Default: 64
+	BufferSize int
+	// Heartbeat is the interval of heartbeat comments sent to idle clients, 0 disables them. Default: 15s
+	Heartbeat time.Duration
+	// History is the number of recent events kept per topic to replay to clients reconnecting with
+	// Last-Event-ID. Default: 0, no replay
+	History int
+	// Retry is the reconnection delay suggested to clients on connect, 0 leaves it to the client. Default: 0
+	Retry time.Duration
+}
+
+// SSEOpt is a functional option for SSEConfig
+type SSEOpt func(*SSEConfig)
+
+// SSEBufferSize sets the number of events queued per client before it is evicted as too slow
+func SSEBufferSize(n int) SSEOpt {
+	return func(c *SSEConfig) {
+		c.BufferSize = n
+	}
+}
+
+// SSEHeartbeat sets the interval of heartbeat comments, 0 disables them
+func SSEHeartbeat(d time.Duration) SSEOpt {
+	return func(c *SSEConfig) {
+		c.Heartbeat = d
+	}
+}
+
+// SSEHistory sets the number of events kept per topic for replay on reconnect
+func SSEHistory(n int) SSEOpt {
+	return func(c *SSEConfig) {
+		c.History = n
+	}
+}
+
+// SSERetry sets the reconnection delay suggested to clients
+func SSERetry(d time.Duration) SSEOpt {
+	return func(c *SSEConfig) {
+		c.Retry = d
+	}
+}
+
+// SSEBroker fans published events out to the clients subscribed to their topic. Each client has its own
+// buffer, so a slow one can't hold up publishing or the others; when the buffer is full the client is
+// evicted instead. Events published without an ID get a broker-wide sequence number, which makes
+// Last-Event-ID replay work across topics.
+type SSEBroker struct {
+	cfg SSEConfig
+
+	mu      sync.RWMutex
+	topics  map[string]map[*sseClient]struct{}
+	history map[string][]sseRecord
+	seq     uint64
+}
+
+// sseRecord is a published event along with its position in the broker's sequence
+type sseRecord struct {
+	seq uint64
+	ev  SSEEvent
+}
+
+type sseClient struct {
+	topics  []string
+	events  chan SSEEvent
+	evicted chan struct{}
+	once    sync.Once
+}
+
+func (c *sseClient) evict() {
+	c.once.Do(func() { close(c.evicted) })
+}
+
+// NewSSEBroker makes a broker with the given options
+func NewSSEBroker(opts ...SSEOpt) *SSEBroker {
+	cfg := SSEConfig{BufferSize: 64, Heartbeat: 15 * time.Second}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 64
+	}
+	return &SSEBroker{
+		cfg:     cfg,
+		topics:  make(map[string]map[*sseClient]struct{}),
+		history: make(map[string][]sseRecord),
+	}
+}
+
+// Publish sends the event to every client subscribed to the topic and returns the number of clients it
+// was queued for. Clients with a full buffer are evicted rather than waited for.
+func (b *SSEBroker) Publish(topic string, ev SSEEvent) int {
+	b.mu.Lock()
+	b.seq++
+	if ev.ID == "" {
+		ev.ID = strconv.FormatUint(b.seq, 10)
+	}
+	if b.cfg.History > 0 {
+		b.history[topic] = append(b.history[topic], sseRecord{seq: b.seq, ev: ev})
+		if extra := len(b.history[topic]) - b.cfg.History; extra > 0 {
+			b.history[topic] = b.history[topic][extra:]
+		}
+	}
+
+	delivered := 0
+	var slow []*sseClient
+	for c := range b.topics[topic] {
+		select {
+		case c.events <- ev:
+			delivered++
+		default:
+			slow = append(slow, c)
+		}
+	}
+	for _, c := range slow {
+		b.unsubscribeLocked(c)
+		c.evict()
+	}
+	b.mu.Unlock()
+	return delivered
+}
+
+// Subscribers returns the number of clients subscribed to the topic
+func (b *SSEBroker) Subscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic])
+}
+
+// Handler streams events of the topics returned by topicsFn to the client until it disconnects or gets
+// evicted. A client reconnecting with Last-Event-ID first gets the events it missed, as far as the kept
+// history reaches back. Requests without topics are answered with StatusBadRequest (400).
+func (b *SSEBroker) Handler(topicsFn func(r *http.Request) []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topics := topicsFn(r)
+		if len(topics) == 0 {
+			_ = EncodeJSON(w, http.StatusBadRequest, JSON{"error": "no topics to subscribe"})
+			return
+		}
+
+		sw, err := NewSSEWriter(w, r)
+		if err != nil {
+			return // headers are out already, nothing else can be sent
+		}
+
+		c, replay := b.subscribe(topics, sw.LastEventID())
+		defer b.unsubscribe(c)
+
+		if b.cfg.Retry > 0 {
+			if err := sw.Send(SSEEvent{Retry: b.cfg.Retry}); err != nil {
+				return
+			}
+		}
+		for _, ev := range replay {
+			if err := sw.Send(ev); err != nil {
+				return
+			}
+		}
+
+		var heartbeat <-chan time.Time
+		if b.cfg.Heartbeat > 0 {
+			ticker := time.NewTicker(b.cfg.Heartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-c.evicted:
+				return
+			case ev := <-c.events:
+				if err := sw.Send(ev); err != nil {
+					return
+				}
+			case <-heartbeat:
+				if err := sw.Heartbeat(); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// subscribe registers a client for the topics and, under the same lock, collects the history events
+// published after lastEventID, so nothing falls between the replay and the live stream
+func (b *SSEBroker) subscribe(topics []string, lastEventID string) (*sseClient, []SSEEvent) {
+	c := &sseClient{topics: topics, events: make(chan SSEEvent, b.cfg.BufferSize), evicted: make(chan struct{})}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range topics {
+		if b.topics[t] == nil {
+			b.topics[t] = make(map[*sseClient]struct{})
+		}
+		b.topics[t][c] = struct{}{}
+	}
+
+	if lastEventID == "" || b.cfg.History == 0 {
+		return c, nil
+	}
+	var after uint64
+	found := false
+	for _, t := range topics {
+		for _, rec := range b.history[t] {
+			if rec.ev.ID == lastEventID {
+				after, found = rec.seq, true
+			}
+		}
+	}
+	if !found {
+		return c, nil // too old or unknown, there is no way to tell what was missed
+	}
+	var missed []sseRecord
+	for _, t := range topics {
+		for _, rec := range b.history[t] {
+			if rec.seq > after {
+				missed = append(missed, rec)
+			}
+		}
+	}
+	slices.SortFunc(missed, func(a, b sseRecord) int { return cmp.Compare(a.seq, b.seq) })
+	res := make([]SSEEvent, 0, len(missed))
+	for _, rec := range missed {
+		res = append(res, rec.ev)
+	}
+	return c, res
+}
+
+func (b *SSEBroker) unsubscribe(c *sseClient) {
+	b.mu.Lock()
+	b.unsubscribeLocked(c)
+	b.mu.Unlock()
+}
+
+func (b *SSEBroker) unsubscribeLocked(c *sseClient) {
+	for _, t := range c.topics {
+		delete(b.topics[t], c)
+		if len(b.topics[t]) == 0 {
+			delete(b.topics, t)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-pkgz/rest/logger"
+)
+
+func TestSSEWriter_Send(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events", http.NoBody)
+	req.Header.Set("Last-Event-ID", "41")
+	w := httptest.NewRecorder()
+
+	sw, err := NewSSEWriter(w, req)
+	require.NoError(t, err)
+	assert.Equal(t, "41", sw.LastEventID())
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.True(t, w.Flushed)
+
+	require.NoError(t, sw.Send(SSEEvent{ID: "42", Event: "update", Data: "line1\nline2\r\nline3"}))
+	require.NoError(t, sw.Send(SSEEvent{ID: "4\n3", Event: "x\r\ndata: injected", Data: "plain"}))
+	require.NoError(t, sw.Send(SSEEvent{Retry: 3 * time.Second}))
+	require.NoError(t, sw.Heartbeat())
+
+	assert.Equal(t, "id: 42\nevent: update\ndata: line1\ndata: line2\ndata: line3\n\n"+
+		"id: 43\nevent: xdata: injected\ndata: plain\n\n"+
+		"retry: 3000\n\n"+
+		": heartbeat\n\n", w.Body.String())
+}
+
+func TestSSEWriter_NoFlusher(t *testing.T) {
+	var sseErr error
+	h := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, sseErr = NewSSEWriter(w, r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", http.NoBody))
+	require.ErrorIs(t, sseErr, http.ErrNotSupported)
+}
+
+func TestSSEBroker_ThroughMiddlewares(t *testing.T) {
+	b := NewSSEBroker(SSERetry(time.Second))
+	topics := func(r *http.Request) []string { return r.URL.Query()["topic"] }
+	lgr := logger.New(logger.Log(&mockLgr{}))
+	ts := httptest.NewServer(Wrap(b.Handler(topics), lgr.Handler, Gzip("text/event-stream")))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/events?topic=news&topic=sport", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	events := bufio.NewReader(gz)
+	assert.Equal(t, "retry: 1000\n", readSSEEvent(t, events))
+
+	require.Eventually(t, func() bool { return b.Subscribers("news") == 1 && b.Subscribers("sport") == 1 },
+		time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, b.Publish("news", SSEEvent{Event: "headline", Data: "hello"}))
+	assert.Equal(t, 0, b.Publish("weather", SSEEvent{Data: "nobody listens"}))
+	assert.Equal(t, 1, b.Publish("sport", SSEEvent{ID: "custom", Data: "score"}))
+
+	assert.Equal(t, "id: 1\nevent: headline\ndata: hello\n", readSSEEvent(t, events))
+	assert.Equal(t, "id: custom\ndata: score\n", readSSEEvent(t, events))
+
+	require.NoError(t, resp.Body.Close())
+	require.Eventually(t, func() bool { return b.Subscribers("news") == 0 && b.Subscribers("sport") == 0 },
+		time.Second, 10*time.Millisecond, "client is dropped after disconnect")
+}
+
+func TestSSEBroker_Replay(t *testing.T) {
+	b := NewSSEBroker(SSEHistory(3))
+	ts := httptest.NewServer(b.Handler(func(*http.Request) []string { return []string{"a", "b"} }))
+	defer ts.Close()
+
+	for _, tp := range []string{"a", "b", "a", "c", "a", "b"} {
+		b.Publish(tp, SSEEvent{Data: tp})
+	}
+	// history of "a" keeps ids 1, 3, 5 and of "b" 2, 6
+
+	connect := func(lastID string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest("GET", ts.URL, http.NoBody)
+		require.NoError(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	resp, events := connect("2")
+	assert.Equal(t, "id: 3\ndata: a\n", readSSEEvent(t, events))
+	assert.Equal(t, "id: 5\ndata: a\n", readSSEEvent(t, events))
+	assert.Equal(t, "id: 6\ndata: b\n", readSSEEvent(t, events))
+	b.Publish("b", SSEEvent{Data: "live"})
+	assert.Equal(t, "id: 7\ndata: live\n", readSSEEvent(t, events))
+	require.NoError(t, resp.Body.Close())
+
+	resp, events = connect("4") // id of a topic the client isn't subscribed to, so unknown
+	defer resp.Body.Close()
+	require.Eventually(t, func() bool { return b.Subscribers("a") == 1 }, time.Second, 10*time.Millisecond)
+	b.Publish("a", SSEEvent{Data: "next"})
+	assert.Equal(t, "id: 8\ndata: next\n", readSSEEvent(t, events))
+}
+
+func TestSSEBroker_EvictsSlowClient(t *testing.T) {
+	b := NewSSEBroker(SSEBufferSize(2))
+	slow, _ := b.subscribe([]string{"t1", "t2"}, "")
+	fast, _ := b.subscribe([]string{"t1"}, "")
+
+	assert.Equal(t, 2, b.Publish("t1", SSEEvent{Data: "1"}))
+	<-fast.events
+	assert.Equal(t, 2, b.Publish("t1", SSEEvent{Data: "2"}))
+	<-fast.events
+	assert.Equal(t, 1, b.Publish("t1", SSEEvent{Data: "3"}), "slow client's buffer is full")
+
+	select {
+	case <-slow.evicted:
+	default:
+		t.Fatal("slow client has to be evicted")
+	}
+	assert.Equal(t, 1, b.Subscribers("t1"))
+	assert.Equal(t, 0, b.Subscribers("t2"), "evicted client is removed from all its topics")
+	assert.Equal(t, "3", (<-fast.events).Data)
+}
+
+func TestSSEBroker_Heartbeat(t *testing.T) {
+	b := NewSSEBroker(SSEHeartbeat(20 * time.Millisecond))
+	ts := httptest.NewServer(b.Handler(func(*http.Request) []string { return []string{"t"} }))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, ": heartbeat\n", readSSEEvent(t, bufio.NewReader(resp.Body)))
+}
+
+func TestSSEBroker_NoTopics(t *testing.T) {
+	b := NewSSEBroker()
+	w := httptest.NewRecorder()
+	b.Handler(func(*http.Request) []string { return nil }).ServeHTTP(w, httptest.NewRequest("GET", "/", http.NoBody))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"error":"no topics to subscribe"}`+"\n", w.Body.String())
+}
+
+// readSSEEvent reads a single event block, up to the blank line ending it
+func readSSEEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var bld strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			t.Fatalf("stream ended, got %q so far", bld.String())
+		}
+		require.NoError(t, err)
+		if line == "\n" {
+			return bld.String()
+		}
+		bld.WriteString(line)
+	}
+}
@@ -0,0 +1,158 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"time"
+)
+
+// StreamConfig defines how often streamed responses are flushed to the client.
+// Use StreamOpt functions to customize.
+type StreamConfig struct {
+	// FlushEvery flushes after this many items, 0 disables the count trigger. Default: 100
+	FlushEvery int
+	// FlushInterval flushes when this much time passed since the previous flush, checked after each item.
+	// 0 disables the time trigger. Default: 1s
+	FlushInterval time.Duration
+}
+
+// StreamOpt is a functional option for StreamConfig
+type StreamOpt func(*StreamConfig)
+
+// StreamFlushEvery sets the number of items written between flushes
+func StreamFlushEvery(n int) StreamOpt {
+	return func(c *StreamConfig) {
+		c.FlushEvery = n
+	}
+}
+
+// StreamFlushInterval sets the longest time written items may wait for a flush
+func StreamFlushInterval(d time.Duration) StreamOpt {
+	return func(c *StreamConfig) {
+		c.FlushInterval = d
+	}
+}
+
+// StreamJSONLines sends items as JSON Lines (application/x-ndjson), one json value per line, encoding and
+// writing each one as it comes from the iterator instead of buffering the whole result. The response is
+// flushed periodically through http.ResponseController, so it keeps streaming behind Gzip and logger
+// middlewares. It stops when the request context is canceled and returns the context's error.
+//
+// The response is committed with the first written item, so an error returned after that (failed encoding
+// of an item, write failure or cancellation) leaves a truncated response the caller can't replace anymore.
+func StreamJSONLines[T any](w http.ResponseWriter, r *http.Request, items iter.Seq[T], opts ...StreamOpt) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	s := newJSONStreamer(w, r, opts)
+	for item := range items {
+		if err := s.write(item, nil); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+// StreamJSONArray sends items as a single json array, encoding and writing each one as it comes from the
+// iterator. Flushing and cancellation work as in StreamJSONLines. If it fails part way, the array is left
+// unterminated, so clients see invalid json rather than a shorter, valid looking result.
+func StreamJSONArray[T any](w http.ResponseWriter, r *http.Request, items iter.Seq[T], opts ...StreamOpt) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	s := newJSONStreamer(w, r, opts)
+	s.array = true
+	if _, err := w.Write([]byte("[")); err != nil {
+		return fmt.Errorf("write json array: %w", err)
+	}
+	sep := []byte{}
+	for item := range items {
+		if err := s.write(item, sep); err != nil {
+			return err
+		}
+		sep = []byte(",")
+	}
+	if _, err := w.Write([]byte("]\n")); err != nil {
+		return fmt.Errorf("write json array: %w", err)
+	}
+	return s.flush()
+}
+
+// SeqFromChan adapts a channel to an iterator for the streaming writers. The iterator ends when the
+// channel is closed or the context is done, whichever comes first, so a stalled producer doesn't keep
+// a stream for a gone client open.
+func SeqFromChan[T any](ctx context.Context, ch <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-ch:
+				if !ok || !yield(v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// jsonStreamer encodes items one by one to the response and flushes it on the configured triggers
+type jsonStreamer struct {
+	w         http.ResponseWriter
+	ctx       context.Context
+	rc        *http.ResponseController
+	cfg       StreamConfig
+	buf       bytes.Buffer
+	enc       *json.Encoder
+	array     bool // items are array elements, written without the encoder's trailing newline
+	pending   int
+	lastFlush time.Time
+}
+
+func newJSONStreamer(w http.ResponseWriter, r *http.Request, opts []StreamOpt) *jsonStreamer {
+	cfg := StreamConfig{FlushEvery: 100, FlushInterval: time.Second}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	res := &jsonStreamer{w: w, ctx: r.Context(), rc: http.NewResponseController(w), cfg: cfg, lastFlush: time.Now()}
+	res.enc = json.NewEncoder(&res.buf)
+	res.enc.SetEscapeHTML(true)
+	return res
+}
+
+// write encodes a single item prefixed by sep, and flushes if one of the triggers fired
+func (s *jsonStreamer) write(item any, sep []byte) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.buf.Reset()
+	s.buf.Write(sep)
+	if err := s.enc.Encode(item); err != nil {
+		return fmt.Errorf("encode json: %w", err)
+	}
+	if s.array {
+		s.buf.Truncate(s.buf.Len() - 1)
+	}
+	if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+		return fmt.Errorf("write json: %w", err)
+	}
+	s.pending++
+
+	if (s.cfg.FlushEvery > 0 && s.pending >= s.cfg.FlushEvery) ||
+		(s.cfg.FlushInterval > 0 && time.Since(s.lastFlush) >= s.cfg.FlushInterval) {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush pushes written items to the client. Writers unable to flush, like the buffering Timeout one,
+// are not an error, the response just reaches the client when the handler is done.
+func (s *jsonStreamer) flush() error {
+	s.pending = 0
+	s.lastFlush = time.Now()
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package rest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-pkgz/rest/logger"
+)
+
+type streamItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestStreamJSONLines(t *testing.T) {
+	items := []streamItem{{1, "one"}, {2, "<two>"}, {3, "three"}}
+	req := httptest.NewRequest("GET", "/items", http.NoBody)
+	w := httptest.NewRecorder()
+
+	require.NoError(t, StreamJSONLines(w, req, slices.Values(items), StreamFlushEvery(2)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, `{"id":1,"name":"one"}`+"\n"+`{"id":2,"name":"\u003ctwo\u003e"}`+"\n"+`{"id":3,"name":"three"}`+"\n",
+		w.Body.String())
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	req := httptest.NewRequest("GET", "/items", http.NoBody)
+
+	t.Run("items", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		items := []streamItem{{1, "one"}, {2, "two"}}
+		require.NoError(t, StreamJSONArray(w, req, slices.Values(items)))
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, `[{"id":1,"name":"one"},{"id":2,"name":"two"}]`+"\n", w.Body.String())
+		var res []streamItem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, items, res)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, StreamJSONArray(w, req, slices.Values([]streamItem{})))
+		assert.Equal(t, "[]\n", w.Body.String())
+	})
+
+	t.Run("encoding failure leaves array unterminated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		err := StreamJSONArray(w, req, slices.Values([]any{1, func() {}}))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "encode json")
+		assert.Equal(t, "[1", w.Body.String())
+	})
+}
+
+func TestStream_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/items", http.NoBody).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	produced := 0
+	items := func(yield func(int) bool) {
+		for i := range 100 {
+			produced++
+			if i == 3 {
+				cancel()
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	err := StreamJSONLines(w, req, items)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 4, produced, "iteration stops right after cancellation")
+	assert.Equal(t, "0\n1\n2\n", w.Body.String())
+}
+
+func TestSeqFromChan(t *testing.T) {
+	t.Run("closed channel", func(t *testing.T) {
+		ch := make(chan int, 3)
+		ch <- 1
+		ch <- 2
+		close(ch)
+		assert.Equal(t, []int{1, 2}, slices.Collect(SeqFromChan(context.Background(), ch)))
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		ch := make(chan int) // never written, never closed
+		assert.Empty(t, slices.Collect(SeqFromChan(ctx, ch)))
+	})
+}
+
+func TestStream_FlushesThroughMiddlewares(t *testing.T) {
+	ch := make(chan streamItem, 1)
+	ch <- streamItem{ID: 0} // the response starts with the first item, so it has to be there before the request
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, StreamJSONLines(w, r, SeqFromChan(r.Context(), ch), StreamFlushEvery(1)))
+	})
+	lgr := logger.New(logger.Log(&mockLgr{}))
+	ts := httptest.NewServer(Wrap(handler, lgr.Handler, Gzip("application/x-ndjson")))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/stream", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	lines := bufio.NewReader(gz)
+
+	// each item has to reach the client before the next one is produced
+	for i := range 3 {
+		if i > 0 {
+			ch <- streamItem{ID: i, Name: strings.Repeat("x", i)}
+		}
+		line, err := lines.ReadString('\n')
+		require.NoError(t, err)
+		var got streamItem
+		require.NoError(t, json.Unmarshal([]byte(line), &got))
+		assert.Equal(t, i, got.ID)
+	}
+	close(ch)
+	tail, err := io.ReadAll(lines)
+	require.NoError(t, err)
+	assert.Empty(t, tail)
+}
@@ -0,0 +1,350 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// UploadError is returned by ParseUpload. Status is the http status the failure maps to:
+//   - 400 for a malformed or interrupted multipart body
+//   - 413 for a file, value, whole body or number of parts over the limit
+//   - 415 for a request that isn't multipart/form-data or a file of a type not allowed
+//   - 500 for a failure to store a file
+type UploadError struct {
+	Status int
+	Field  string // form field of the failed part, empty for request level failures
+	Err    error
+}
+
+// Error implements error interface
+func (e *UploadError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("upload %q: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("upload: %v", e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *UploadError) Unwrap() error { return e.Err }
+
+// UploadedFile describes a file part of an upload. ContentType is sniffed from the content, the type
+// declared by the client is not trusted. Path is set for files stored in a temp file.
+type UploadedFile struct {
+	FieldName   string
+	FileName    string // base name as sent by the client, not safe to use as a path
+	ContentType string
+	Size        int64
+	Path        string
+}
+
+// Open opens the temp file the upload was stored in
+func (f *UploadedFile) Open() (*os.File, error) {
+	if f.Path == "" {
+		return nil, fmt.Errorf("file %q was not stored in a temp file", f.FileName)
+	}
+	return os.Open(f.Path)
+}
+
+// UploadSinkFunc receives a file part instead of a temp file. Reading content fails once a limit is
+// crossed, and the sink has to return that error. Size of the file is set after the sink returns.
+type UploadSinkFunc func(file *UploadedFile, content io.Reader) error
+
+// UploadConfig defines ParseUpload limits and storage.
+// Use UploadOpt functions to customize.
+type UploadConfig struct {
+	// MaxFileSize is the size limit of a single file, 0 means no limit. Default: 32MB
+	MaxFileSize int64
+	// MaxValueSize is the size limit of a single non-file value, those are kept in memory. Default: 1MB
+	MaxValueSize int64
+	// MaxTotalSize is the size limit of the whole request body, 0 means no limit. Default: 100MB
+	MaxTotalSize int64
+	// MaxParts is the number of parts, files and values, allowed in a request, 0 means no limit. Default: 100
+	MaxParts int
+	// AllowedTypes lists media types files may have, like "application/pdf" or "image/*". Types are sniffed
+	// with http.DetectContentType. Default: empty, any type allowed
+	AllowedTypes []string
+	// TempDir is the directory of temp files. Default: empty, os.TempDir
+	TempDir string
+	// Sink receives files instead of temp files. Default: nil, files stored in temp files
+	Sink UploadSinkFunc
+}
+
+// UploadOpt is a functional option for UploadConfig
+type UploadOpt func(*UploadConfig)
+
+// UploadMaxFileSize sets the size limit of a single file
+func UploadMaxFileSize(size int64) UploadOpt {
+	return func(c *UploadConfig) {
+		c.MaxFileSize = size
+	}
+}
+
+// UploadMaxValueSize sets the size limit of a single non-file value
+func UploadMaxValueSize(size int64) UploadOpt {
+	return func(c *UploadConfig) {
+		c.MaxValueSize = size
+	}
+}
+
+// UploadMaxTotalSize sets the size limit of the whole request body
+func UploadMaxTotalSize(size int64) UploadOpt {
+	return func(c *UploadConfig) {
+		c.MaxTotalSize = size
+	}
+}
+
+// UploadMaxParts sets the number of parts allowed in a request
+func UploadMaxParts(n int) UploadOpt {
+	return func(c *UploadConfig) {
+		c.MaxParts = n
+	}
+}
+
+// UploadAllowedTypes sets media types files may have, "type/*" allows all subtypes
+func UploadAllowedTypes(types ...string) UploadOpt {
+	return func(c *UploadConfig) {
+		c.AllowedTypes = types
+	}
+}
+
+// UploadTempDir sets the directory of temp files
+func UploadTempDir(dir string) UploadOpt {
+	return func(c *UploadConfig) {
+		c.TempDir = dir
+	}
+}
+
+// UploadSink makes files go to the sink rather than to temp files
+func UploadSink(fn UploadSinkFunc) UploadOpt {
+	return func(c *UploadConfig) {
+		c.Sink = fn
+	}
+}
+
+// Upload is a parsed multipart/form-data request
+type Upload struct {
+	Values url.Values
+	Files  []*UploadedFile
+
+	mu     sync.Mutex
+	temp   []string
+	closed bool
+	stop   func() bool
+}
+
+// File returns the first file uploaded in the field, nil if there is none
+func (u *Upload) File(field string) *UploadedFile {
+	for _, f := range u.Files {
+		if f.FieldName == field {
+			return f
+		}
+	}
+	return nil
+}
+
+// Cleanup removes temp files. It runs by itself when the request ends, so calling it is only needed to
+// free the disk early. A file moved away before that is left alone.
+func (u *Upload) Cleanup() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.stop != nil {
+		u.stop()
+	}
+	u.closed = true
+	var errs []error
+	for _, path := range u.temp {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	u.temp = nil
+	return errors.Join(errs...)
+}
+
+// ParseUpload reads a multipart/form-data request part by part, storing files in temp files or passing them
+// to the sink, so files never sit in memory whole. Limits are checked while reading and failures come back
+// as *UploadError. Temp files are removed when the request's context is done, which for a server request
+// happens once the handler returns; move a file elsewhere to keep it.
+func ParseUpload(r *http.Request, opts ...UploadOpt) (*Upload, error) {
+	cfg := UploadConfig{MaxFileSize: 32 << 20, MaxValueSize: 1 << 20, MaxTotalSize: 100 << 20, MaxParts: 100}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, &UploadError{Status: http.StatusUnsupportedMediaType,
+			Err: fmt.Errorf("unsupported content type %q", r.Header.Get("Content-Type"))}
+	}
+	if params["boundary"] == "" {
+		return nil, &UploadError{Status: http.StatusBadRequest, Err: errors.New("no multipart boundary")}
+	}
+	tooLarge := &UploadError{Status: http.StatusRequestEntityTooLarge,
+		Err: fmt.Errorf("request body is larger than %d bytes", cfg.MaxTotalSize)}
+	if cfg.MaxTotalSize > 0 && r.ContentLength > cfg.MaxTotalSize {
+		return nil, tooLarge
+	}
+	mr := multipart.NewReader(&uploadLimitReader{r: r.Body, limit: cfg.MaxTotalSize, tooLarge: tooLarge},
+		params["boundary"])
+
+	u := &Upload{Values: url.Values{}}
+	u.stop = context.AfterFunc(r.Context(), func() { _ = u.Cleanup() })
+	fail := func(err error) (*Upload, error) {
+		_ = u.Cleanup()
+		return nil, err
+	}
+
+	for parts := 1; ; parts++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(uploadReadError(err, ""))
+		}
+		if cfg.MaxParts > 0 && parts > cfg.MaxParts {
+			return fail(&UploadError{Status: http.StatusRequestEntityTooLarge,
+				Err: fmt.Errorf("more than %d parts", cfg.MaxParts)})
+		}
+
+		field := part.FormName()
+		if part.FileName() == "" {
+			value, err := io.ReadAll(&uploadLimitReader{r: part, limit: cfg.MaxValueSize,
+				tooLarge: &UploadError{Status: http.StatusRequestEntityTooLarge, Field: field,
+					Err: fmt.Errorf("value is larger than %d bytes", cfg.MaxValueSize)}})
+			if err != nil {
+				return fail(uploadReadError(err, field))
+			}
+			u.Values.Add(field, string(value))
+			continue
+		}
+
+		f, err := u.storeFile(part, cfg)
+		if err != nil {
+			return fail(err)
+		}
+		u.Files = append(u.Files, f)
+	}
+	return u, nil
+}
+
+func (u *Upload) storeFile(part *multipart.Part, cfg UploadConfig) (*UploadedFile, error) {
+	f := &UploadedFile{FieldName: part.FormName(), FileName: part.FileName()}
+	content := &uploadLimitReader{r: part, limit: cfg.MaxFileSize,
+		tooLarge: &UploadError{Status: http.StatusRequestEntityTooLarge, Field: f.FieldName,
+			Err: fmt.Errorf("file %q is larger than %d bytes", f.FileName, cfg.MaxFileSize)}}
+	br := bufio.NewReaderSize(content, 512)
+
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, uploadReadError(err, f.FieldName)
+	}
+	f.ContentType = http.DetectContentType(head)
+	if !uploadTypeAllowed(f.ContentType, cfg.AllowedTypes) {
+		return nil, &UploadError{Status: http.StatusUnsupportedMediaType, Field: f.FieldName,
+			Err: fmt.Errorf("file %q of type %q is not allowed", f.FileName, f.ContentType)}
+	}
+
+	if cfg.Sink != nil {
+		err = cfg.Sink(f, br)
+	} else {
+		err = u.writeTemp(f, br, cfg.TempDir)
+	}
+	f.Size = content.n
+	if content.err != nil {
+		return nil, uploadReadError(content.err, f.FieldName) // reading failed, whatever the sink made of it
+	}
+	if err != nil {
+		return nil, &UploadError{Status: http.StatusInternalServerError, Field: f.FieldName, Err: err}
+	}
+	return f, nil
+}
+
+func (u *Upload) writeTemp(f *UploadedFile, content io.Reader, dir string) error {
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return errors.New("upload is cleaned up already")
+	}
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		u.mu.Unlock()
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	u.temp = append(u.temp, tmp.Name())
+	u.mu.Unlock()
+
+	f.Path = tmp.Name()
+	_, err = io.Copy(tmp, content)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close temp file: %w", closeErr)
+	}
+	return err
+}
+
+func uploadTypeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, mediaType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, strings.ToLower(prefix)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadReadError keeps limit errors as they are and reports everything else as a bad request
+func uploadReadError(err error, field string) error {
+	var ue *UploadError
+	if errors.As(err, &ue) {
+		return ue
+	}
+	return &UploadError{Status: http.StatusBadRequest, Field: field, Err: fmt.Errorf("read multipart: %w", err)}
+}
+
+// uploadLimitReader fails with tooLarge once more than limit bytes are read. It remembers the first
+// failure, so it can be told apart from a failure of the consumer.
+type uploadLimitReader struct {
+	r        io.Reader
+	limit    int64 // 0 means no limit
+	tooLarge error
+	n        int64
+	err      error
+}
+
+func (l *uploadLimitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if l.limit > 0 && int64(len(p)) > l.limit-l.n+1 {
+		p = p[:l.limit-l.n+1] // one byte past the limit is enough to tell it is crossed
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit > 0 && l.n > l.limit {
+		l.err = l.tooLarge
+		return n - int(l.n-l.limit), l.err
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		l.err = err
+	}
+	return n, err
+}
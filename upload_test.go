@@ -0,0 +1,201 @@
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+type uploadPart struct {
+	field, file string
+	content     []byte
+}
+
+func makeUploadRequest(t *testing.T, parts ...uploadPart) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, p := range parts {
+		if p.file == "" {
+			require.NoError(t, mw.WriteField(p.field, string(p.content)))
+			continue
+		}
+		fw, err := mw.CreateFormFile(p.field, p.file)
+		require.NoError(t, err)
+		_, err = fw.Write(p.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestParseUpload(t *testing.T) {
+	dir := t.TempDir()
+	req := makeUploadRequest(t,
+		uploadPart{field: "title", content: []byte("my pics")},
+		uploadPart{field: "pic", file: "../../a.png", content: append(pngHeader, bytes.Repeat([]byte{1}, 1000)...)},
+		uploadPart{field: "doc", file: "notes.txt", content: []byte("some notes")},
+	)
+
+	u, err := ParseUpload(req, UploadTempDir(dir))
+	require.NoError(t, err)
+	assert.Equal(t, "my pics", u.Values.Get("title"))
+	require.Len(t, u.Files, 2)
+
+	pic := u.File("pic")
+	require.NotNil(t, pic)
+	assert.Equal(t, "a.png", pic.FileName)
+	assert.Equal(t, "image/png", pic.ContentType)
+	assert.Equal(t, int64(len(pngHeader)+1000), pic.Size)
+	fh, err := pic.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(fh)
+	require.NoError(t, err)
+	require.NoError(t, fh.Close())
+	assert.Equal(t, pngHeader, data[:len(pngHeader)])
+
+	doc := u.File("doc")
+	require.NotNil(t, doc)
+	assert.Equal(t, "text/plain; charset=utf-8", doc.ContentType)
+	assert.Nil(t, u.File("nope"))
+
+	require.NoError(t, u.Cleanup())
+	_, err = os.Stat(pic.Path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(doc.Path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseUpload_CleanupOnRequestEnd(t *testing.T) {
+	dir := t.TempDir()
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := ParseUpload(r, UploadTempDir(dir))
+		require.NoError(t, err)
+		path = u.File("f").Path
+		_, err = os.Stat(path)
+		assert.NoError(t, err, "file exists while the request is served")
+	}))
+	defer ts.Close()
+
+	req := makeUploadRequest(t, uploadPart{field: "f", file: "f.txt", content: []byte("content")})
+	resp, err := http.Post(ts.URL, req.Header.Get("Content-Type"), req.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) == 0
+	}, time.Second, 10*time.Millisecond, "temp file removed after the request")
+	assert.NotEmpty(t, path)
+}
+
+func TestParseUpload_Limits(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 2000)
+	tbl := []struct {
+		name   string
+		parts  []uploadPart
+		opts   []UploadOpt
+		status int
+		field  string
+	}{
+		{"file too large", []uploadPart{{field: "f", file: "a.txt", content: big}},
+			[]UploadOpt{UploadMaxFileSize(1000)}, http.StatusRequestEntityTooLarge, "f"},
+		{"file at the limit", []uploadPart{{field: "f", file: "a.txt", content: big}},
+			[]UploadOpt{UploadMaxFileSize(2000)}, 0, ""},
+		{"value too large", []uploadPart{{field: "v", content: big}},
+			[]UploadOpt{UploadMaxValueSize(100)}, http.StatusRequestEntityTooLarge, "v"},
+		{"total too large", []uploadPart{{field: "f1", file: "a.txt", content: big}, {field: "f2", file: "b.txt", content: big}},
+			[]UploadOpt{UploadMaxTotalSize(3000)}, http.StatusRequestEntityTooLarge, ""},
+		{"too many parts", []uploadPart{{field: "a", content: []byte("1")}, {field: "b", content: []byte("2")},
+			{field: "c", content: []byte("3")}}, []UploadOpt{UploadMaxParts(2)}, http.StatusRequestEntityTooLarge, ""},
+		{"type not allowed", []uploadPart{{field: "f", file: "fake.png", content: []byte("<html><body>hi")}},
+			[]UploadOpt{UploadAllowedTypes("image/*", "application/pdf")}, http.StatusUnsupportedMediaType, "f"},
+		{"type allowed by wildcard", []uploadPart{{field: "f", file: "a.png", content: pngHeader}},
+			[]UploadOpt{UploadAllowedTypes("image/*")}, 0, ""},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			req := makeUploadRequest(t, tt.parts...)
+			req.ContentLength = -1 // make limits checked while reading rather than upfront
+			u, err := ParseUpload(req, append(tt.opts, UploadTempDir(dir))...)
+			if tt.status == 0 {
+				require.NoError(t, err)
+				require.NoError(t, u.Cleanup())
+				return
+			}
+			var ue *UploadError
+			require.ErrorAs(t, err, &ue)
+			assert.Equal(t, tt.status, ue.Status)
+			assert.Equal(t, tt.field, ue.Field)
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries, "temp files removed on failure")
+		})
+	}
+}
+
+func TestParseUpload_Sink(t *testing.T) {
+	stored := map[string]string{}
+	sink := func(f *UploadedFile, content io.Reader) error {
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		if f.FileName == "broken.txt" {
+			return errors.New("storage is down")
+		}
+		stored[f.FileName] = string(data)
+		return nil
+	}
+
+	u, err := ParseUpload(makeUploadRequest(t, uploadPart{field: "f", file: "a.txt", content: []byte("hello")}),
+		UploadSink(sink))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a.txt": "hello"}, stored)
+	assert.Equal(t, int64(5), u.File("f").Size)
+	assert.Empty(t, u.File("f").Path)
+
+	_, err = ParseUpload(makeUploadRequest(t, uploadPart{field: "f", file: "b.txt", content: []byte(strings.Repeat("x", 100))}),
+		UploadSink(sink), UploadMaxFileSize(10))
+	var ue *UploadError
+	require.ErrorAs(t, err, &ue)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, ue.Status, "limit applies to sinks too")
+
+	_, err = ParseUpload(makeUploadRequest(t, uploadPart{field: "f", file: "broken.txt", content: []byte("x")}),
+		UploadSink(sink))
+	require.ErrorAs(t, err, &ue)
+	assert.Equal(t, http.StatusInternalServerError, ue.Status)
+	assert.EqualError(t, err, `upload "f": storage is down`)
+}
+
+func TestParseUpload_NotMultipart(t *testing.T) {
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	_, err := ParseUpload(req)
+	var ue *UploadError
+	require.ErrorAs(t, err, &ue)
+	assert.Equal(t, http.StatusUnsupportedMediaType, ue.Status)
+
+	req = httptest.NewRequest("POST", "/upload", strings.NewReader("--xyz\r\nbroken"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=xyz")
+	_, err = ParseUpload(req)
+	require.ErrorAs(t, err, &ue)
+	assert.Equal(t, http.StatusBadRequest, ue.Status)
+}
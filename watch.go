@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// watchedFile reloads a file when it changes, checking its mtime and size at most once per interval.
+// Checks are made on use, from the request path, so nothing runs in the background; a caller finding
+// a check already in progress goes on with what was loaded before instead of waiting.
+type watchedFile struct {
+	path     string
+	interval time.Duration
+	now      func() time.Time
+
+	checked atomic.Int64 // unix nanoseconds of the last check
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+func newWatchedFile(path string, interval time.Duration) *watchedFile {
+	return &watchedFile{path: path, interval: interval, now: time.Now}
+}
+
+// load reads the file and passes its content to fn unconditionally
+func (f *watchedFile) load(fn func(data []byte) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checked.Store(f.now().UnixNano())
+	return f.loadLocked(fn)
+}
+
+// poll passes the file's content to fn if the interval has passed and the file changed since it was loaded.
+// The change is taken as seen even if fn fails, so a broken file is reported once, not on every check.
+func (f *watchedFile) poll(fn func(data []byte) error) error {
+	now := f.now()
+	if now.Sub(time.Unix(0, f.checked.Load())) < f.interval {
+		return nil
+	}
+	if !f.mu.TryLock() {
+		return nil
+	}
+	defer f.mu.Unlock()
+	if now.Sub(time.Unix(0, f.checked.Load())) < f.interval {
+		return nil // checked by someone else meanwhile
+	}
+	f.checked.Store(now.UnixNano())
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("can't stat %s: %w", f.path, err)
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+	return f.loadLocked(fn)
+}
+
+func (f *watchedFile) loadLocked(fn func(data []byte) error) error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("can't stat %s: %w", f.path, err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("can't read %s: %w", f.path, err)
+	}
+	f.modTime, f.size = fi.ModTime(), fi.Size()
+	if err = fn(data); err != nil {
+		return fmt.Errorf("can't load %s: %w", f.path, err)
+	}
+	return nil
+}